@@ -0,0 +1,430 @@
+package bitbucketserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/oauth2"
+)
+
+type bitbucketApi struct {
+	baseUrl *url.URL
+	client  *models.ApiClient
+}
+
+func BitbucketApi(baseUrl *url.URL, source oauth2.TokenSource, timeout time.Duration) *bitbucketApi {
+	return &bitbucketApi{
+		baseUrl: baseUrl,
+		client:  models.NewApiClient(baseUrl, source, timeout),
+	}
+}
+
+func (b *bitbucketApi) doGetRequest(ctx context.Context, path string, queryParams url.Values) (*http.Response, error) {
+	return b.client.Get(ctx, path, queryParams, nil)
+}
+
+// splitSlug splits a slug of the form PROJECT/repo (or ~user/repo for
+// personal repositories) into the escaped repository path used by the REST API.
+func splitSlug(slug string) (string, error) {
+	parts := strings.Split(slug, "/")
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", fmt.Errorf("invalid repository slug: %s", slug)
+	}
+
+	return fmt.Sprintf("/rest/api/1.0/projects/%s/repos/%s", url.PathEscape(parts[0]), url.PathEscape(parts[1])), nil
+}
+
+func (b *bitbucketApi) getSelfUsername(ctx context.Context) (string, error) {
+	resp, err := b.doGetRequest(ctx, "/plugins/servlet/applinks/whoami", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	username, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response data: %w", err)
+	}
+
+	if len(username) == 0 {
+		return "", &models.ProviderError{Kind: models.ErrUnauthorized}
+	}
+
+	return strings.TrimSpace(string(username)), nil
+}
+
+func (b *bitbucketApi) getSelfUser(ctx context.Context) (*User, error) {
+	username, err := b.getSelfUsername(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current username: %w", err)
+	}
+
+	resp, err := b.doGetRequest(ctx, fmt.Sprintf("/rest/api/1.0/users/%s", url.PathEscape(username)), url.Values{"avatarSize": []string{"64"}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject User
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	if len(respObject.AvatarUrl) > 0 {
+		if avatarUrl, err := url.Parse(respObject.AvatarUrl); err == nil {
+			respObject.AvatarUrl = b.baseUrl.ResolveReference(avatarUrl).String()
+		}
+	}
+
+	return &respObject, nil
+}
+
+func (b *bitbucketApi) getDefaultBranch(ctx context.Context, repoPath string) (*RepositoryBranch, error) {
+	resp, err := b.doGetRequest(ctx, repoPath+"/branches/default", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject RepositoryBranch
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (b *bitbucketApi) getProject(ctx context.Context, slug string) (*Project, error) {
+	repoPath, err := splitSlug(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.doGetRequest(ctx, repoPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject Project
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	defaultBranch, err := b.getDefaultBranch(ctx, repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default branch: %w", err)
+	}
+
+	respObject.DefaultBranch = defaultBranch.DisplayId
+
+	return &respObject, nil
+}
+
+func (b *bitbucketApi) getProjectBranch(ctx context.Context, slug, branchName string) (*RepositoryBranch, error) {
+	repoPath, err := splitSlug(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.doGetRequest(ctx, repoPath+"/branches", url.Values{
+		"filterText": []string{branchName},
+		"limit":      []string{"100"},
+	})
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject BranchPage
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	for _, branch := range respObject.Values {
+		if branch.DisplayId == branchName {
+			return &branch, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (b *bitbucketApi) getProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error) {
+	repoPath, err := splitSlug(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.doGetRequest(ctx, fmt.Sprintf("%s/raw/%s", repoPath, models.EscapeFilePath(path)),
+		url.Values{"at": []string{ref}})
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (b *bitbucketApi) listProjects(ctx context.Context, search string, page int) ([]Project, int, error) {
+	queryParams := url.Values{
+		"permission": []string{"REPO_READ"},
+		"start":      []string{strconv.Itoa((page - 1) * models.ListPageSize)},
+		"limit":      []string{strconv.Itoa(models.ListPageSize)},
+	}
+
+	if len(search) > 0 {
+		queryParams.Set("name", search)
+	}
+
+	resp, err := b.doGetRequest(ctx, "/rest/api/1.0/repos", queryParams)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject ProjectPage
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	nextPage := 0
+	if !respObject.IsLastPage {
+		nextPage = page + 1
+	}
+
+	return respObject.Values, nextPage, nil
+}
+
+func (b *bitbucketApi) listProjectBranches(ctx context.Context, slug, search string, page int) ([]RepositoryBranch, int, error) {
+	repoPath, err := splitSlug(slug)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	queryParams := url.Values{
+		"start": []string{strconv.Itoa((page - 1) * models.ListPageSize)},
+		"limit": []string{strconv.Itoa(models.ListPageSize)},
+	}
+
+	if len(search) > 0 {
+		queryParams.Set("filterText", search)
+	}
+
+	resp, err := b.doGetRequest(ctx, repoPath+"/branches", queryParams)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject BranchPage
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	nextPage := 0
+	if !respObject.IsLastPage {
+		nextPage = page + 1
+	}
+
+	return respObject.Values, nextPage, nil
+}
+
+func (b *bitbucketApi) getMergeRequest(ctx context.Context, slug string, number int) (*PullRequest, error) {
+	repoPath, err := splitSlug(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.doGetRequest(ctx, fmt.Sprintf("%s/pull-requests/%d", repoPath, number), nil)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject PullRequest
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (b *bitbucketApi) getProjectTag(ctx context.Context, slug, tagName string) (*RepositoryTag, error) {
+	repoPath, err := splitSlug(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.doGetRequest(ctx, repoPath+"/tags", url.Values{
+		"filterText": []string{tagName},
+		"limit":      []string{"100"},
+	})
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject TagPage
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	for _, tag := range respObject.Values {
+		if tag.DisplayId == tagName {
+			return &tag, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (b *bitbucketApi) getProjectCommit(ctx context.Context, slug, sha string) (*Commit, error) {
+	repoPath, err := splitSlug(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.doGetRequest(ctx, fmt.Sprintf("%s/commits/%s", repoPath, url.PathEscape(sha)), nil)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject Commit
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (b *bitbucketApi) GetSelfUser(ctx context.Context) (models.User, error) {
+	return b.getSelfUser(ctx)
+}
+
+func (b *bitbucketApi) GetProject(ctx context.Context, slug string) (models.Project, error) {
+	return b.getProject(ctx, slug)
+}
+
+func (b *bitbucketApi) DoesProjectBranchExist(ctx context.Context, slug, branchName string) (bool, error) {
+	branch, err := b.getProjectBranch(ctx, slug, branchName)
+	if err != nil {
+		return false, fmt.Errorf("failed to query branch: %w", err)
+	}
+
+	return branch != nil, nil
+}
+
+func (b *bitbucketApi) GetProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error) {
+	return b.getProjectFile(ctx, slug, ref, path)
+}
+
+func (b *bitbucketApi) ListProjects(ctx context.Context, search string, page int) ([]models.Project, int, error) {
+	projects, nextPage, err := b.listProjects(ctx, search, page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]models.Project, len(projects))
+	for i := range projects {
+		result[i] = &projects[i]
+	}
+
+	return result, nextPage, nil
+}
+
+func (b *bitbucketApi) ListBranches(ctx context.Context, slug, search string, page int) ([]models.Branch, int, error) {
+	branches, nextPage, err := b.listProjectBranches(ctx, slug, search, page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]models.Branch, len(branches))
+	for i := range branches {
+		result[i] = &branches[i]
+	}
+
+	return result, nextPage, nil
+}
+
+func (b *bitbucketApi) GetMergeRequest(ctx context.Context, slug string, number int) (models.MergeRequest, error) {
+	pullRequest, err := b.getMergeRequest(ctx, slug, number)
+	if err != nil || pullRequest == nil {
+		return nil, err
+	}
+
+	return pullRequest, nil
+}
+
+func (b *bitbucketApi) ResolveRef(ctx context.Context, slug, ref string) (*models.ResolvedRef, error) {
+	branch, err := b.getProjectBranch(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query branch: %w", err)
+	}
+	if branch != nil {
+		return &models.ResolvedRef{Name: branch.DisplayId, Type: models.RefTypeBranch, Commit: branch.LatestCommit}, nil
+	}
+
+	tag, err := b.getProjectTag(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tag: %w", err)
+	}
+	if tag != nil {
+		return &models.ResolvedRef{Name: tag.DisplayId, Type: models.RefTypeTag, Commit: tag.LatestCommit}, nil
+	}
+
+	if !models.LooksLikeCommitSha(ref) {
+		return nil, nil
+	}
+
+	commit, err := b.getProjectCommit(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query commit: %w", err)
+	}
+	if commit != nil {
+		return &models.ResolvedRef{Name: ref, Type: models.RefTypeCommit, Commit: commit.Id}, nil
+	}
+
+	return nil, nil
+}
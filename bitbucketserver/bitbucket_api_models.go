@@ -0,0 +1,161 @@
+package bitbucketserver
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/* ================================================================================ */
+
+type User struct {
+	Id           int64  `json:"id"`
+	Name         string `json:"name"`
+	Slug         string `json:"slug"`
+	DisplayName  string `json:"displayName"`
+	EmailAddress string `json:"emailAddress"`
+	AvatarUrl    string `json:"avatarUrl"`
+}
+
+func (u *User) GetId() string {
+	return strconv.FormatInt(u.Id, 10)
+}
+
+func (u *User) GetUsername() string {
+	return u.Slug
+}
+
+func (u *User) GetDisplayName() string {
+	return u.DisplayName
+}
+
+func (u *User) GetEmail() string {
+	return u.EmailAddress
+}
+
+func (u *User) GetAvatarUrl() string {
+	return u.AvatarUrl
+}
+
+func (u *User) GetIsAdmin() bool {
+	return false
+}
+
+/* ================================================================================ */
+
+type Link struct {
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+type ProjectRef struct {
+	Key string `json:"key"`
+}
+
+type Project struct {
+	Slug    string     `json:"slug"`
+	Project ProjectRef `json:"project"`
+	Links   struct {
+		Clone []Link `json:"clone"`
+	} `json:"links"`
+
+	DefaultBranch string `json:"-"`
+}
+
+func (p *Project) GetFullName() string {
+	return p.Project.Key + "/" + p.Slug
+}
+
+func (p *Project) GetHttpCloneUrl() string {
+	for _, link := range p.Links.Clone {
+		if link.Name == "http" || link.Name == "https" {
+			return link.Href
+		}
+	}
+
+	return ""
+}
+
+func (p *Project) GetDefaultBranch() string {
+	return p.DefaultBranch
+}
+
+/* ================================================================================ */
+
+type RepositoryBranch struct {
+	Id           string `json:"id"`
+	DisplayId    string `json:"displayId"`
+	LatestCommit string `json:"latestCommit"`
+}
+
+func (b *RepositoryBranch) GetName() string {
+	return b.DisplayId
+}
+
+type BranchPage struct {
+	Values        []RepositoryBranch `json:"values"`
+	IsLastPage    bool               `json:"isLastPage"`
+	NextPageStart int                `json:"nextPageStart"`
+}
+
+type ProjectPage struct {
+	Values     []Project `json:"values"`
+	IsLastPage bool      `json:"isLastPage"`
+}
+
+/* ================================================================================ */
+
+type PullRequestRef struct {
+	DisplayId    string `json:"displayId"`
+	LatestCommit string `json:"latestCommit"`
+	Repository   struct {
+		Slug    string     `json:"slug"`
+		Project ProjectRef `json:"project"`
+	} `json:"repository"`
+}
+
+type PullRequest struct {
+	Id      int            `json:"id"`
+	FromRef PullRequestRef `json:"fromRef"`
+	ToRef   PullRequestRef `json:"toRef"`
+}
+
+func (p *PullRequest) GetNumber() int {
+	return p.Id
+}
+
+func (p *PullRequest) GetSourceBranch() string {
+	return p.FromRef.DisplayId
+}
+
+func (p *PullRequest) GetTargetBranch() string {
+	return p.ToRef.DisplayId
+}
+
+func (p *PullRequest) GetHeadSha() string {
+	return p.FromRef.LatestCommit
+}
+
+func (p *PullRequest) GetHeadRef() string {
+	return fmt.Sprintf("refs/pull-requests/%d/from", p.Id)
+}
+
+func (p *PullRequest) IsFromFork() bool {
+	return p.FromRef.Repository.Slug != p.ToRef.Repository.Slug ||
+		p.FromRef.Repository.Project.Key != p.ToRef.Repository.Project.Key
+}
+
+/* ================================================================================ */
+
+type RepositoryTag struct {
+	Id           string `json:"id"`
+	DisplayId    string `json:"displayId"`
+	LatestCommit string `json:"latestCommit"`
+}
+
+type TagPage struct {
+	Values []RepositoryTag `json:"values"`
+}
+
+type Commit struct {
+	Id string `json:"id"`
+}
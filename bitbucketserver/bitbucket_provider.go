@@ -0,0 +1,38 @@
+package bitbucketserver
+
+import (
+	"errors"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	models.RegisterRepositoryProvider("bitbucket-server", &bitbucketProviderFactory{})
+}
+
+type bitbucketProviderFactory struct{}
+
+func (f *bitbucketProviderFactory) ValidateSettings(settings *models.ProviderSettings) error {
+	if settings.BaseUrl == nil || len(settings.BaseUrl.Host) == 0 {
+		return errors.New("base_url is required")
+	}
+
+	if len(settings.AuthEndpoint) == 0 {
+		settings.AuthEndpoint = "/rest/oauth2/latest/authorize"
+	}
+
+	if len(settings.TokenEndpoint) == 0 {
+		settings.TokenEndpoint = "/rest/oauth2/latest/token"
+	}
+
+	if len(settings.Scopes) == 0 {
+		settings.Scopes = []string{"REPO_READ"}
+	}
+
+	return nil
+}
+
+func (f *bitbucketProviderFactory) NewRepositoryProvider(settings *models.ProviderSettings, source oauth2.TokenSource) (models.RepositoryProvider, error) {
+	return BitbucketApi(settings.BaseUrl, source, settings.RequestTimeout), nil
+}
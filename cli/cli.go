@@ -0,0 +1,163 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dogboy21/poddy/poddy"
+	"github.com/spf13/pflag"
+)
+
+type command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(args []string) error
+}
+
+var commands = []command{
+	{
+		Name:        "serve",
+		Usage:       "serve",
+		Description: "Start the poddy server",
+		Run:         runServe,
+	},
+	{
+		Name:        "login",
+		Usage:       "login <server-url> [--token <token>]",
+		Description: "Log in to a poddy server",
+		Run:         runLogin,
+	},
+	{
+		Name:        "ws",
+		Usage:       "ws <command>",
+		Description: "Manage workspaces",
+		Run:         runWorkspaces,
+	},
+}
+
+var workspaceCommands = []command{
+	{
+		Name:        "create",
+		Usage:       "ws create <repo-url> [--branch <branch>] [--merge-request <number>]",
+		Description: "Create a workspace for a repository",
+		Run:         runWorkspaceCreate,
+	},
+	{
+		Name:        "list",
+		Usage:       "ws list [--project <project>] [--sort <key>]",
+		Description: "List your workspaces",
+		Run:         runWorkspaceList,
+	},
+	{
+		Name:        "status",
+		Usage:       "ws status <name>",
+		Description: "Show the status of a workspace",
+		Run:         runWorkspaceStatus,
+	},
+	{
+		Name:        "delete",
+		Usage:       "ws delete <name>",
+		Description: "Delete a workspace",
+		Run:         runWorkspaceDelete,
+	},
+	{
+		Name:        "open",
+		Usage:       "ws open <name>",
+		Description: "Open a workspace in the browser",
+		Run:         runWorkspaceOpen,
+	},
+	{
+		Name:        "watch",
+		Usage:       "ws watch",
+		Description: "Print changes of your workspaces as they happen",
+		Run:         runWorkspaceWatch,
+	},
+	{
+		Name:        "logs",
+		Usage:       "ws logs <name> [--container <container>] [--follow]",
+		Description: "Print the logs of a workspace",
+		Run:         runWorkspaceLogs,
+	},
+}
+
+// Run executes the command given by the arguments, without the program name.
+func Run(args []string) error {
+	return runCommand(commands, "poddy", args)
+}
+
+func runCommand(commands []command, prefix string, args []string) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		printCommands(commands, prefix)
+		return nil
+	}
+
+	for _, command := range commands {
+		if command.Name == args[0] {
+			err := command.Run(args[1:])
+			if errors.Is(err, pflag.ErrHelp) {
+				return nil
+			}
+
+			return err
+		}
+	}
+
+	printCommands(commands, prefix)
+	return fmt.Errorf("unknown command %q", strings.TrimSpace(prefix+" "+args[0]))
+}
+
+func printCommands(commands []command, prefix string) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command>\n\nCommands:\n", prefix)
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, command := range commands {
+		fmt.Fprintf(w, "  poddy %s\t%s\n", command.Usage, command.Description)
+	}
+	w.Flush()
+}
+
+// newFlagSet creates the flags of a command including the output format flag.
+func newFlagSet(usage string) (*pflag.FlagSet, *string) {
+	flags := pflag.NewFlagSet(usage, pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: poddy %s\n\nFlags:\n%s", usage, flags.FlagUsages())
+	}
+
+	output := flags.StringP("output", "o", "text", "output format, either text or json")
+
+	return flags, output
+}
+
+func parseFlags(flags *pflag.FlagSet, output *string, args []string, argCount int) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *output != "text" && *output != "json" {
+		return fmt.Errorf("unknown output format %q", *output)
+	}
+
+	if flags.NArg() != argCount {
+		flags.Usage()
+		return fmt.Errorf("expected %d argument(s) but got %d", argCount, flags.NArg())
+	}
+
+	return nil
+}
+
+func runServe(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("serve doesn't take any arguments")
+	}
+
+	poddy.Start()
+	return nil
+}
+
+func runWorkspaces(args []string) error {
+	return runCommand(workspaceCommands, "poddy ws", args)
+}
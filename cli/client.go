@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const clientRequestTimeout = 2 * time.Minute
+
+// apiError is returned for unsuccessful responses of the poddy API.
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	if e.StatusCode == http.StatusUnauthorized {
+		return fmt.Sprintf("%s, run poddy login", e.Message)
+	}
+
+	return e.Message
+}
+
+type client struct {
+	server     *url.URL
+	token      string
+	httpClient *http.Client
+}
+
+func newClient() (*client, error) {
+	creds, err := loadCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	return newClientFor(creds.Server, creds.Token)
+}
+
+func newClientFor(server, token string) (*client, error) {
+	serverUrl, err := url.Parse(strings.TrimSuffix(server, "/"))
+	if err != nil || len(serverUrl.Scheme) == 0 || len(serverUrl.Host) == 0 {
+		return nil, fmt.Errorf("invalid server url %q", server)
+	}
+
+	return &client{
+		server:     serverUrl,
+		token:      token,
+		httpClient: http.DefaultClient,
+	}, nil
+}
+
+func (c *client) request(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	requestUrl := *c.server
+	requestUrl.Path += path
+	requestUrl.RawQuery = query.Encode()
+
+	var requestBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		requestBody = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl.String(), requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach poddy server: %w", err)
+	}
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+
+	errorResponse := struct {
+		Error string `json:"error"`
+	}{}
+	json.NewDecoder(resp.Body).Decode(&errorResponse)
+
+	if len(errorResponse.Error) == 0 {
+		errorResponse.Error = strings.ToLower(http.StatusText(resp.StatusCode))
+	}
+
+	return nil, &apiError{
+		StatusCode: resp.StatusCode,
+		Message:    fmt.Sprintf("%s %s failed: %s", method, path, errorResponse.Error),
+	}
+}
+
+// do sends a request to the poddy API and decodes the response into result
+// unless it's nil.
+func (c *client) do(method, path string, body, result interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clientRequestTimeout)
+	defer cancel()
+
+	resp, err := c.request(ctx, method, path, nil, body)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// stream sends a request without a timeout and returns the response body,
+// which has to be closed by the caller.
+func (c *client) stream(path string, query url.Values) (io.ReadCloser, error) {
+	resp, err := c.request(context.Background(), http.MethodGet, path, query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
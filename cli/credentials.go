@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// credentials are the server and api token used by the client commands. They
+// can be overridden by the PODDY_SERVER and PODDY_TOKEN environment variables,
+// e.g. in CI jobs.
+type credentials struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+}
+
+func credentialsPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config directory: %w", err)
+	}
+
+	return filepath.Join(configDir, "poddy", "credentials.json"), nil
+}
+
+func loadCredentials() (*credentials, error) {
+	creds := &credentials{}
+
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(data, creds); err != nil {
+			return nil, fmt.Errorf("failed to parse credentials %s: %w", path, err)
+		}
+	}
+
+	if server := os.Getenv("PODDY_SERVER"); len(server) > 0 {
+		creds.Server = server
+	}
+
+	if token := os.Getenv("PODDY_TOKEN"); len(token) > 0 {
+		creds.Token = token
+	}
+
+	if len(creds.Server) == 0 || len(creds.Token) == 0 {
+		return nil, errors.New("not logged in, run poddy login first")
+	}
+
+	return creds, nil
+}
+
+func (c *credentials) save() error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+
+	return nil
+}
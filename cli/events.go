@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// errStopEvents ends reading the event stream without an error.
+var errStopEvents = errors.New("stop reading events")
+
+// serverEvent is a single event of a server-sent event stream.
+type serverEvent struct {
+	Name string
+	Data string
+}
+
+// readEvents passes the server-sent events of the stream to the handler until
+// the stream ends or the handler returns an error.
+func readEvents(r io.Reader, handle func(event serverEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var event serverEvent
+	var data []string
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if len(line) == 0 {
+			if len(event.Name) > 0 || len(data) > 0 {
+				event.Data = strings.Join(data, "\n")
+				if err := handle(event); err != nil {
+					if errors.Is(err, errStopEvents) {
+						return nil
+					}
+
+					return err
+				}
+			}
+
+			event = serverEvent{}
+			data = nil
+			continue
+		}
+
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i >= 0 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+
+		switch field {
+		case "event":
+			event.Name = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+
+	return scanner.Err()
+}
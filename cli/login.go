@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const browserLoginTimeout = 5 * time.Minute
+
+type selfResponse struct {
+	User *struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Accounts []struct {
+		Provider string `json:"provider"`
+		Host     string `json:"host"`
+		User     struct {
+			Username string `json:"username"`
+		} `json:"user"`
+	} `json:"accounts"`
+}
+
+func runLogin(args []string) error {
+	flags, output := newFlagSet("login <server-url> [--token <token>]")
+	token := flags.String("token", "", "api token to use instead of logging in through the browser")
+
+	if err := parseFlags(flags, output, args, 1); err != nil {
+		return err
+	}
+
+	server := strings.TrimSuffix(flags.Arg(0), "/")
+	if _, err := newClientFor(server, ""); err != nil {
+		return err
+	}
+
+	if len(*token) == 0 {
+		browserToken, err := browserLogin(server)
+		if err != nil {
+			return err
+		}
+
+		*token = browserToken
+	}
+
+	c, err := newClientFor(server, *token)
+	if err != nil {
+		return err
+	}
+
+	var self selfResponse
+	if err := c.do(http.MethodGet, "/api/v1/self", nil, &self); err != nil {
+		return fmt.Errorf("failed to verify token: %w", err)
+	}
+
+	creds := &credentials{
+		Server: server,
+		Token:  *token,
+	}
+
+	if err := creds.save(); err != nil {
+		return err
+	}
+
+	return printResult(*output, self, func(w io.Writer) {
+		if self.User != nil {
+			fmt.Fprintf(w, "Logged in to %s as %s\n", server, self.User.Username)
+		} else {
+			fmt.Fprintf(w, "Logged in to %s\n", server)
+		}
+
+		for _, account := range self.Accounts {
+			fmt.Fprintf(w, "  %s\t%s\n", account.Host, account.User.Username)
+		}
+	})
+}
+
+// browserLogin lets the server mint an api token in the browser session and
+// receives it on a local callback listener.
+func browserLogin(server string) (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to listen for the login callback: %w", err)
+	}
+
+	defer listener.Close()
+
+	stateBytes := make([]byte, 16)
+	rand.Read(stateBytes)
+	state := hex.EncodeToString(stateBytes)
+
+	tokens := make(chan string, 1)
+	httpServer := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/callback" || r.URL.Query().Get("state") != state {
+				http.Error(w, "invalid login callback", http.StatusBadRequest)
+				return
+			}
+
+			fmt.Fprintln(w, "Logged in, you can close this window.")
+
+			select {
+			case tokens <- r.URL.Query().Get("token"):
+			default:
+			}
+		}),
+	}
+
+	go httpServer.Serve(listener)
+	defer httpServer.Shutdown(context.Background())
+
+	hostname, _ := os.Hostname()
+
+	loginUrl := fmt.Sprintf("%s/auth/cli?%s", server, url.Values{
+		"port":  {fmt.Sprint(listener.Addr().(*net.TCPAddr).Port)},
+		"state": {state},
+		"name":  {hostname},
+	}.Encode())
+
+	fmt.Fprintf(os.Stderr, "Opening %s in your browser, confirm the login there\n", loginUrl)
+	if err := openBrowser(loginUrl); err != nil {
+		fmt.Fprintf(os.Stderr, "%v, open the url manually\n", err)
+	}
+
+	select {
+	case token := <-tokens:
+		if len(token) == 0 {
+			return "", errors.New("login callback did not contain a token")
+		}
+
+		return token, nil
+	case <-time.After(browserLoginTimeout):
+		return "", errors.New("timed out waiting for the browser login")
+	}
+}
@@ -0,0 +1,463 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type workspace struct {
+	Provider     string            `json:"provider"`
+	Name         string            `json:"name"`
+	Url          string            `json:"url"`
+	Project      string            `json:"project"`
+	Ref          string            `json:"ref,omitempty"`
+	RefType      string            `json:"ref_type,omitempty"`
+	MergeRequest int               `json:"merge_request,omitempty"`
+	Commit       string            `json:"commit,omitempty"`
+	Ide          string            `json:"ide"`
+	CreatedAt    time.Time         `json:"created_at"`
+	ServiceUrls  map[string]string `json:"service_urls"`
+	Status       string            `json:"status"`
+	StatusReason string            `json:"status_reason,omitempty"`
+}
+
+type workspaceStatus struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type workspaceListResponse struct {
+	Items []workspace `json:"items"`
+}
+
+type createWorkspaceRequest struct {
+	Host         string `json:"host"`
+	Project      string `json:"project"`
+	Ref          string `json:"ref,omitempty"`
+	MergeRequest int    `json:"merge_request,omitempty"`
+}
+
+var (
+	scpLikeUrlPattern           = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.+)$`)
+	bitbucketPullRequestPattern = regexp.MustCompile(`^projects/([^/]+)/repos/([^/]+)/pull-requests/(\d+)`)
+	mergeRequestPattern         = regexp.MustCompile(`^(.+?)/(?:-/merge_requests|pulls?)/(\d+)`)
+	bitbucketBrowsePattern      = regexp.MustCompile(`^projects/([^/]+)/repos/([^/]+)(?:/browse)?`)
+	treeSeparators              = []string{"/-/tree/", "/-/commit/", "/commit/", "/src/branch/", "/src/tag/", "/src/commit/", "/tree/"}
+)
+
+// parseRepositoryUrl turns a clone url or the url of a branch, commit or merge
+// request page into a workspace creation request.
+func parseRepositoryUrl(rawUrl string) (*createWorkspaceRequest, error) {
+	if !strings.Contains(rawUrl, "://") {
+		match := scpLikeUrlPattern.FindStringSubmatch(rawUrl)
+		if match == nil {
+			return nil, fmt.Errorf("invalid repository url %q", rawUrl)
+		}
+
+		rawUrl = fmt.Sprintf("ssh://%s/%s", match[1], match[2])
+	}
+
+	repositoryUrl, err := url.Parse(rawUrl)
+	if err != nil || len(repositoryUrl.Host) == 0 {
+		return nil, fmt.Errorf("invalid repository url %q", rawUrl)
+	}
+
+	request := &createWorkspaceRequest{
+		Host: repositoryUrl.Host,
+	}
+	if repositoryUrl.Scheme == "ssh" {
+		request.Host = repositoryUrl.Hostname()
+	}
+
+	path := strings.Trim(repositoryUrl.Path, "/")
+
+	if match := bitbucketPullRequestPattern.FindStringSubmatch(path); match != nil {
+		request.Project = match[1] + "/" + match[2]
+		request.MergeRequest, _ = strconv.Atoi(match[3])
+		return request, nil
+	}
+
+	if match := mergeRequestPattern.FindStringSubmatch(path); match != nil {
+		request.Project = match[1]
+		request.MergeRequest, _ = strconv.Atoi(match[2])
+		return request, nil
+	}
+
+	if match := bitbucketBrowsePattern.FindStringSubmatch(path); match != nil {
+		request.Project = match[1] + "/" + match[2]
+		request.Ref = strings.TrimPrefix(strings.TrimPrefix(repositoryUrl.Query().Get("at"), "refs/heads/"), "refs/tags/")
+		return request, nil
+	}
+
+	for _, separator := range treeSeparators {
+		if parts := strings.SplitN(path, separator, 2); len(parts) == 2 {
+			request.Project = parts[0]
+			request.Ref = parts[1]
+			return request, nil
+		}
+	}
+
+	request.Project = strings.TrimSuffix(path, ".git")
+	if len(request.Project) == 0 {
+		return nil, fmt.Errorf("repository url %q doesn't contain a project", rawUrl)
+	}
+
+	return request, nil
+}
+
+func listWorkspaces(c *client, query url.Values) ([]workspace, error) {
+	path := "/api/v1/workspaces"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var response workspaceListResponse
+	if err := c.do(http.MethodGet, path, nil, &response); err != nil {
+		return nil, err
+	}
+
+	return response.Items, nil
+}
+
+// findWorkspace looks up the workspace by its name, which can be prefixed with
+// the provider id as in <provider>/<name>.
+func findWorkspace(c *client, name string) (*workspace, error) {
+	workspaces, err := listWorkspaces(c, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := ""
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+		provider, name = parts[0], parts[1]
+	}
+
+	var found *workspace
+	for i, workspace := range workspaces {
+		if workspace.Name != name || (len(provider) > 0 && workspace.Provider != provider) {
+			continue
+		}
+
+		if found != nil {
+			return nil, fmt.Errorf("workspace %s exists for several providers, use <provider>/%s", name, name)
+		}
+
+		found = &workspaces[i]
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("workspace %s not found", name)
+	}
+
+	return found, nil
+}
+
+// formatAge formats the time since the workspace was created in its largest
+// unit.
+func formatAge(createdAt time.Time) string {
+	age := time.Since(createdAt)
+
+	switch {
+	case age >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(age/(24*time.Hour)))
+	case age >= time.Hour:
+		return fmt.Sprintf("%dh", int(age/time.Hour))
+	case age >= time.Minute:
+		return fmt.Sprintf("%dm", int(age/time.Minute))
+	}
+
+	return fmt.Sprintf("%ds", int(age/time.Second))
+}
+
+func runWorkspaceCreate(args []string) error {
+	flags, output := newFlagSet("ws create <repo-url> [--branch <branch>] [--merge-request <number>]")
+	branch := flags.StringP("branch", "b", "", "branch, tag or commit to check out")
+	mergeRequest := flags.Int("merge-request", 0, "merge request to check out")
+
+	if err := parseFlags(flags, output, args, 1); err != nil {
+		return err
+	}
+
+	if len(*branch) > 0 && *mergeRequest > 0 {
+		return errors.New("either --branch or --merge-request can be given")
+	}
+
+	request, err := parseRepositoryUrl(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if len(*branch) > 0 {
+		request.Ref = *branch
+		request.MergeRequest = 0
+	}
+
+	if *mergeRequest > 0 {
+		request.Ref = ""
+		request.MergeRequest = *mergeRequest
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	var created workspace
+	if err := c.do(http.MethodPost, "/api/v1/workspaces", request, &created); err != nil {
+		return err
+	}
+
+	return printResult(*output, created, func(w io.Writer) {
+		fmt.Fprintf(w, "Created workspace %s\n%s\n", created.Name, created.Url)
+	})
+}
+
+func runWorkspaceList(args []string) error {
+	flags, output := newFlagSet("ws list [--project <project>] [--sort <key>]")
+	project := flags.String("project", "", "only list workspaces of the project")
+	sortKey := flags.String("sort", "", "sort by created_at or project, prefix with - for descending order")
+
+	if err := parseFlags(flags, output, args, 0); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{}
+	if len(*project) > 0 {
+		query.Set("project", *project)
+	}
+
+	if len(*sortKey) > 0 {
+		query.Set("sort", *sortKey)
+	}
+
+	workspaces, err := listWorkspaces(c, query)
+	if err != nil {
+		return err
+	}
+
+	return printResult(*output, workspaces, func(w io.Writer) {
+		if len(workspaces) == 0 {
+			fmt.Fprintln(w, "No workspaces")
+			return
+		}
+
+		fmt.Fprintln(w, "PROVIDER\tNAME\tPROJECT\tREF\tCOMMIT\tSTATUS\tAGE\tURL")
+		for _, workspace := range workspaces {
+			ref := workspace.Ref
+			if workspace.MergeRequest > 0 {
+				ref = "#" + strconv.Itoa(workspace.MergeRequest)
+			}
+
+			commit := workspace.Commit
+			if len(commit) > 8 {
+				commit = commit[:8]
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", workspace.Provider, workspace.Name, workspace.Project, ref, commit, workspace.Status, formatAge(workspace.CreatedAt), workspace.Url)
+		}
+	})
+}
+
+func runWorkspaceStatus(args []string) error {
+	flags, output := newFlagSet("ws status <name>")
+
+	if err := parseFlags(flags, output, args, 1); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	found, err := findWorkspace(c, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var current workspace
+	if err := c.do(http.MethodGet, fmt.Sprintf("/api/v1/workspaces/%s/%s", found.Provider, found.Name), nil, &current); err != nil {
+		return err
+	}
+
+	return printResult(*output, current, func(w io.Writer) {
+		fmt.Fprintf(w, "Workspace:\t%s\n", current.Name)
+		fmt.Fprintf(w, "Project:\t%s\n", current.Project)
+		fmt.Fprintf(w, "Status:\t%s\n", current.Status)
+		if len(current.StatusReason) > 0 {
+			fmt.Fprintf(w, "Reason:\t%s\n", current.StatusReason)
+		}
+		fmt.Fprintf(w, "Url:\t%s\n", current.Url)
+	})
+}
+
+func runWorkspaceDelete(args []string) error {
+	flags, output := newFlagSet("ws delete <name>")
+
+	if err := parseFlags(flags, output, args, 1); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	found, err := findWorkspace(c, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if err := c.do(http.MethodDelete, fmt.Sprintf("/api/v1/workspaces/%s/%s", found.Provider, found.Name), nil, nil); err != nil {
+		return err
+	}
+
+	return printResult(*output, found, func(w io.Writer) {
+		fmt.Fprintf(w, "Deleted workspace %s\n", found.Name)
+	})
+}
+
+func runWorkspaceOpen(args []string) error {
+	flags, output := newFlagSet("ws open <name>")
+
+	if err := parseFlags(flags, output, args, 1); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	found, err := findWorkspace(c, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if found.Status != "running" {
+		fmt.Fprintf(os.Stderr, "workspace %s is %s and may not be reachable yet\n", found.Name, found.Status)
+	}
+
+	if err := openBrowser(found.Url); err != nil {
+		fmt.Fprintf(os.Stderr, "%v, open the url manually\n", err)
+	}
+
+	return printResult(*output, found, func(w io.Writer) {
+		fmt.Fprintln(w, found.Url)
+	})
+}
+
+func runWorkspaceLogs(args []string) error {
+	flags, output := newFlagSet("ws logs <name> [--container <container>] [--follow]")
+	container := flags.StringP("container", "c", "", "container to print the logs of, either workspace-setup or code-server")
+	follow := flags.BoolP("follow", "f", false, "keep streaming new log lines")
+
+	if err := parseFlags(flags, output, args, 1); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	found, err := findWorkspace(c, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{
+		"follow": {strconv.FormatBool(*follow)},
+	}
+
+	if len(*container) > 0 {
+		query.Set("container", *container)
+	}
+
+	logs, err := c.stream(fmt.Sprintf("/api/v1/workspaces/%s/%s/logs", found.Provider, found.Name), query)
+	if err != nil {
+		return err
+	}
+
+	defer logs.Close()
+
+	return readEvents(logs, func(event serverEvent) error {
+		switch event.Name {
+		case "log":
+			return printResult(*output, map[string]string{"line": event.Data}, func(w io.Writer) {
+				fmt.Fprintln(w, event.Data)
+			})
+		case "status":
+			var status workspaceStatus
+			if err := json.Unmarshal([]byte(event.Data), &status); err != nil {
+				return fmt.Errorf("failed to parse status: %w", err)
+			}
+
+			if len(status.Reason) > 0 {
+				fmt.Fprintf(os.Stderr, "waiting for the workspace, it is %s: %s\n", status.Status, status.Reason)
+			} else {
+				fmt.Fprintf(os.Stderr, "waiting for the workspace, it is %s\n", status.Status)
+			}
+		case "error":
+			return errors.New(event.Data)
+		case "end":
+			return errStopEvents
+		}
+
+		return nil
+	})
+}
+
+func runWorkspaceWatch(args []string) error {
+	flags, output := newFlagSet("ws watch")
+
+	if err := parseFlags(flags, output, args, 0); err != nil {
+		return err
+	}
+
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	events, err := c.stream("/api/v1/events", nil)
+	if err != nil {
+		return err
+	}
+
+	defer events.Close()
+
+	return readEvents(events, func(event serverEvent) error {
+		var changed workspace
+		if err := json.Unmarshal([]byte(event.Data), &changed); err != nil {
+			return fmt.Errorf("failed to parse workspace event: %w", err)
+		}
+
+		result := map[string]interface{}{
+			"type":      event.Name,
+			"workspace": changed,
+		}
+
+		return printResult(*output, result, func(w io.Writer) {
+			if len(changed.StatusReason) > 0 {
+				fmt.Fprintf(w, "%s %s/%s %s: %s\n", event.Name, changed.Provider, changed.Name, changed.Status, changed.StatusReason)
+			} else {
+				fmt.Fprintf(w, "%s %s/%s %s\n", event.Name, changed.Provider, changed.Name, changed.Status)
+			}
+		})
+	})
+}
@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRepositoryUrl(t *testing.T) {
+	tests := []struct {
+		rawUrl  string
+		want    *createWorkspaceRequest
+		wantErr bool
+	}{
+		{
+			rawUrl: "https://github.com/jdoe/poddy.git",
+			want:   &createWorkspaceRequest{Host: "github.com", Project: "jdoe/poddy"},
+		},
+		{
+			rawUrl: "git@github.com:jdoe/poddy.git",
+			want:   &createWorkspaceRequest{Host: "github.com", Project: "jdoe/poddy"},
+		},
+		{
+			rawUrl: "ssh://git@gitlab.example.com:2222/group/subgroup/poddy.git",
+			want:   &createWorkspaceRequest{Host: "gitlab.example.com", Project: "group/subgroup/poddy"},
+		},
+		{
+			rawUrl: "https://gitea.example.com:3000/jdoe/poddy",
+			want:   &createWorkspaceRequest{Host: "gitea.example.com:3000", Project: "jdoe/poddy"},
+		},
+		{
+			rawUrl: "https://github.com/jdoe/poddy/tree/feature/login",
+			want:   &createWorkspaceRequest{Host: "github.com", Project: "jdoe/poddy", Ref: "feature/login"},
+		},
+		{
+			rawUrl: "https://gitlab.example.com/group/poddy/-/tree/main",
+			want:   &createWorkspaceRequest{Host: "gitlab.example.com", Project: "group/poddy", Ref: "main"},
+		},
+		{
+			rawUrl: "https://gitea.example.com/jdoe/poddy/src/tag/v1.0.0",
+			want:   &createWorkspaceRequest{Host: "gitea.example.com", Project: "jdoe/poddy", Ref: "v1.0.0"},
+		},
+		{
+			rawUrl: "https://github.com/jdoe/poddy/commit/4a5b6c7",
+			want:   &createWorkspaceRequest{Host: "github.com", Project: "jdoe/poddy", Ref: "4a5b6c7"},
+		},
+		{
+			rawUrl: "https://github.com/jdoe/poddy/pull/42",
+			want:   &createWorkspaceRequest{Host: "github.com", Project: "jdoe/poddy", MergeRequest: 42},
+		},
+		{
+			rawUrl: "https://gitlab.example.com/group/poddy/-/merge_requests/7",
+			want:   &createWorkspaceRequest{Host: "gitlab.example.com", Project: "group/poddy", MergeRequest: 7},
+		},
+		{
+			rawUrl: "https://bitbucket.example.com/projects/PRJ/repos/poddy/pull-requests/3/overview",
+			want:   &createWorkspaceRequest{Host: "bitbucket.example.com", Project: "PRJ/poddy", MergeRequest: 3},
+		},
+		{
+			rawUrl: "https://bitbucket.example.com/projects/PRJ/repos/poddy/browse?at=refs/heads/develop",
+			want:   &createWorkspaceRequest{Host: "bitbucket.example.com", Project: "PRJ/poddy", Ref: "develop"},
+		},
+		{rawUrl: "poddy", wantErr: true},
+		{rawUrl: "https://github.com/", wantErr: true},
+		{rawUrl: "file:///srv/git/poddy.git", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rawUrl, func(t *testing.T) {
+			got, err := parseRepositoryUrl(tt.rawUrl)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRepositoryUrl() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRepositoryUrl() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
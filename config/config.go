@@ -1,10 +1,13 @@
 package config
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"log"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -18,17 +21,60 @@ const (
 	keyDeploymentNamespace    = "deployment.namespace"
 	keyDeploymentBaseDomain   = "deployment.baseDomain"
 	keyDeploymentIngressClass = "deployment.ingressClass"
+
+	keyDeploymentAuth              = "deployment.auth"
+	keyDeploymentTraefikMiddleware = "deployment.traefikMiddleware"
+
+	keyOidcIssuerUrl     = "oidc.issuerUrl"
+	keyOidcClientId      = "oidc.clientId"
+	keyOidcClientSecret  = "oidc.clientSecret"
+	keyOidcScopes        = "oidc.scopes"
+	keyOidcUsernameClaim = "oidc.usernameClaim"
+
+	keySessionsStore     = "sessions.store"
+	keySessionsFilePath  = "sessions.filePath"
+	keySessionsNamespace = "sessions.namespace"
+
+	keyTimeoutsProviderRequest   = "timeouts.providerRequest"
+	keyTimeoutsKubernetesRequest = "timeouts.kubernetesRequest"
+)
+
+const defaultCookieSecret = "abcdef"
+
+// how requests to workspaces are authenticated: by the ingress controllers
+// the workspace ingresses are annotated for, or by poddy proxying all
+// workspace traffic itself without any workspace ingresses
+const (
+	DeploymentAuthNginx   = "nginx"
+	DeploymentAuthTraefik = "traefik"
+	DeploymentAuthProxy   = "proxy"
+	DeploymentAuthNone    = "none"
 )
 
 func setDefaults() {
 	viper.SetDefault(keyServerListenAddress, ":8080")
 	viper.SetDefault(keyServerUrl, "http://poddy.127.0.0.1.nip.io:8080")
-	viper.SetDefault(keyServerCookieSecret, "abcdef")
+	viper.SetDefault(keyServerCookieSecret, defaultCookieSecret)
 	viper.SetDefault(keyServerSecureCookies, false)
 
 	viper.SetDefault(keyDeploymentNamespace, "poddy-workspaces")
 	viper.SetDefault(keyDeploymentBaseDomain, "poddy.127.0.0.1.nip.io")
 	viper.SetDefault(keyDeploymentIngressClass, "")
+	viper.SetDefault(keyDeploymentAuth, DeploymentAuthNginx)
+	viper.SetDefault(keyDeploymentTraefikMiddleware, "poddy-workspace-auth@kubernetescrd")
+
+	viper.SetDefault(keyOidcIssuerUrl, "")
+	viper.SetDefault(keyOidcClientId, "")
+	viper.SetDefault(keyOidcClientSecret, "")
+	viper.SetDefault(keyOidcScopes, []string{"openid", "profile", "email"})
+	viper.SetDefault(keyOidcUsernameClaim, "preferred_username")
+
+	viper.SetDefault(keySessionsStore, "file")
+	viper.SetDefault(keySessionsFilePath, "data/sessions.db")
+	viper.SetDefault(keySessionsNamespace, "")
+
+	viper.SetDefault(keyTimeoutsProviderRequest, "15s")
+	viper.SetDefault(keyTimeoutsKubernetesRequest, "10s")
 }
 
 func ReadConfig() error {
@@ -56,14 +102,6 @@ func ReadConfig() error {
 	return nil
 }
 
-func mustParseHex(val []byte, err error) []byte {
-	if err != nil {
-		log.Fatalf("failed to parse config value as hex: %v\n", err)
-	}
-
-	return val
-}
-
 func ServerListenAddress() string {
 	return viper.GetString(keyServerListenAddress)
 }
@@ -77,8 +115,75 @@ func ServerUrl() *url.URL {
 	return urlObj
 }
 
-func ServerCookieSecret() []byte {
-	return mustParseHex(hex.DecodeString(viper.GetString(keyServerCookieSecret)))
+type CookieKeyPair struct {
+	Authentication string `mapstructure:"authentication"`
+	Encryption     string `mapstructure:"encryption"`
+}
+
+// ServerCookieSecretIsDefault reports whether the cookie secret has been left
+// at its publicly known default.
+func ServerCookieSecretIsDefault() bool {
+	secret, ok := viper.Get(keyServerCookieSecret).(string)
+	return ok && (len(secret) == 0 || secret == defaultCookieSecret)
+}
+
+// ServerCookieKeyPairs returns the authentication and encryption key pairs for
+// the session cookies, newest first. Cookies are written with the first pair
+// while all pairs are accepted when reading, which allows rotating keys by
+// prepending a new pair. server.cookieSecret is either a list of pairs or a
+// string of comma separated "authentication:encryption" hex pairs. A pair
+// without an encryption key derives one from its authentication key.
+func ServerCookieKeyPairs() ([][]byte, error) {
+	var cookieKeyPairs []CookieKeyPair
+
+	switch secret := viper.Get(keyServerCookieSecret).(type) {
+	case string:
+		for _, pair := range strings.Split(secret, ",") {
+			keys := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+
+			cookieKeyPair := CookieKeyPair{Authentication: keys[0]}
+			if len(keys) == 2 {
+				cookieKeyPair.Encryption = keys[1]
+			}
+
+			cookieKeyPairs = append(cookieKeyPairs, cookieKeyPair)
+		}
+	default:
+		if err := viper.UnmarshalKey(keyServerCookieSecret, &cookieKeyPairs); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", keyServerCookieSecret, err)
+		}
+	}
+
+	if len(cookieKeyPairs) == 0 {
+		return nil, fmt.Errorf("%s must contain at least one key pair", keyServerCookieSecret)
+	}
+
+	keyPairs := make([][]byte, 0, 2*len(cookieKeyPairs))
+	for i, cookieKeyPair := range cookieKeyPairs {
+		authenticationKey, err := hex.DecodeString(cookieKeyPair.Authentication)
+		if err != nil || len(authenticationKey) == 0 {
+			return nil, fmt.Errorf("invalid authentication key in key pair #%d of %s", i, keyServerCookieSecret)
+		}
+
+		var encryptionKey []byte
+		if len(cookieKeyPair.Encryption) > 0 {
+			encryptionKey, err = hex.DecodeString(cookieKeyPair.Encryption)
+			if err != nil {
+				return nil, fmt.Errorf("invalid encryption key in key pair #%d of %s: %w", i, keyServerCookieSecret, err)
+			}
+
+			if keyLength := len(encryptionKey); keyLength != 16 && keyLength != 24 && keyLength != 32 {
+				return nil, fmt.Errorf("encryption key in key pair #%d of %s must be 16, 24 or 32 bytes long", i, keyServerCookieSecret)
+			}
+		} else {
+			derivedKey := sha256.Sum256(append([]byte("poddy-cookie-encryption:"), authenticationKey...))
+			encryptionKey = derivedKey[:]
+		}
+
+		keyPairs = append(keyPairs, authenticationKey, encryptionKey)
+	}
+
+	return keyPairs, nil
 }
 
 func ServerSecureCookies() bool {
@@ -96,3 +201,61 @@ func DeploymentBaseDomain() string {
 func DeploymentIngressClass() string {
 	return viper.GetString(keyDeploymentIngressClass)
 }
+
+func DeploymentAuth() string {
+	return viper.GetString(keyDeploymentAuth)
+}
+
+// DeploymentTraefikMiddleware is the forwardAuth middleware referenced by the
+// workspace ingresses, which has to be set up to point to /auth/workspace.
+func DeploymentTraefikMiddleware() string {
+	return viper.GetString(keyDeploymentTraefikMiddleware)
+}
+
+func OidcEnabled() bool {
+	return len(OidcIssuerUrl()) > 0
+}
+
+func OidcIssuerUrl() string {
+	return viper.GetString(keyOidcIssuerUrl)
+}
+
+func OidcClientId() string {
+	return viper.GetString(keyOidcClientId)
+}
+
+func OidcClientSecret() string {
+	return viper.GetString(keyOidcClientSecret)
+}
+
+func OidcScopes() []string {
+	return viper.GetStringSlice(keyOidcScopes)
+}
+
+func OidcUsernameClaim() string {
+	return viper.GetString(keyOidcUsernameClaim)
+}
+
+func SessionsStore() string {
+	return viper.GetString(keySessionsStore)
+}
+
+func SessionsFilePath() string {
+	return viper.GetString(keySessionsFilePath)
+}
+
+func SessionsNamespace() string {
+	if namespace := viper.GetString(keySessionsNamespace); len(namespace) > 0 {
+		return namespace
+	}
+
+	return DeploymentNamespace()
+}
+
+func TimeoutsProviderRequest() time.Duration {
+	return viper.GetDuration(keyTimeoutsProviderRequest)
+}
+
+func TimeoutsKubernetesRequest() time.Duration {
+	return viper.GetDuration(keyTimeoutsKubernetesRequest)
+}
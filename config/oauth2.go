@@ -1,30 +1,47 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"reflect"
+	"strings"
+	"time"
 
-	gitlab2 "github.com/dogboy21/poddy/gitlab"
 	"github.com/dogboy21/poddy/models"
 
 	"github.com/spf13/viper"
 	"golang.org/x/oauth2"
 )
 
+const (
+	LoginModeOauth       = "oauth"
+	LoginModeToken       = "token"
+	LoginModeCredentials = "credentials"
+)
+
 type OauthRepositoryProviderConfig struct {
 	ID            string   `mapstructure:"id"`
 	Type          string   `mapstructure:"type"`
 	ClientID      string   `mapstructure:"client_id"`
 	ClientSecret  string   `mapstructure:"client_secret"`
 	BaseUrl       string   `mapstructure:"base_url"`
+	ApiUrl        string   `mapstructure:"api_url"`
 	AuthEndpoint  string   `mapstructure:"auth_endpoint"`
 	TokenEndpoint string   `mapstructure:"token_endpoint"`
 	Scopes        []string `mapstructure:"scopes"`
+	Pkce          *bool    `mapstructure:"pkce"`
+	LoginModes    []string `mapstructure:"login_modes"`
+
+	ProbeRepository string `mapstructure:"probe_repository"`
+	SshKnownHosts   string `mapstructure:"ssh_known_hosts"`
+
+	RequestTimeout time.Duration `mapstructure:"request_timeout"`
 
-	parsedBaseUrl *url.URL
-	OauthConfig   *oauth2.Config
-	Host          string
+	factory     models.RepositoryProviderFactory
+	settings    *models.ProviderSettings
+	OauthConfig *oauth2.Config
+	Host        string
 }
 
 func (c *OauthRepositoryProviderConfig) GetOauthConfig() *oauth2.Config {
@@ -32,27 +49,154 @@ func (c *OauthRepositoryProviderConfig) GetOauthConfig() *oauth2.Config {
 		ClientID:     c.ClientID,
 		ClientSecret: c.ClientSecret,
 		Endpoint: oauth2.Endpoint{
-			AuthURL:  c.parsedBaseUrl.ResolveReference(&url.URL{Path: c.AuthEndpoint}).String(),
-			TokenURL: c.parsedBaseUrl.ResolveReference(&url.URL{Path: c.TokenEndpoint}).String(),
+			AuthURL:  c.settings.BaseUrl.ResolveReference(&url.URL{Path: c.settings.AuthEndpoint}).String(),
+			TokenURL: c.settings.BaseUrl.ResolveReference(&url.URL{Path: c.settings.TokenEndpoint}).String(),
 		},
 		RedirectURL: ServerUrl().ResolveReference(&url.URL{Path: fmt.Sprintf("/oauth/redirect/%s", c.ID)}).String(),
-		Scopes:      c.Scopes,
+		Scopes:      c.settings.Scopes,
+	}
+}
+
+// UsePkce reports whether the authorization code flow is secured with PKCE,
+// which is the default unless disabled for providers that reject it.
+func (c *OauthRepositoryProviderConfig) UsePkce() bool {
+	return c.Pkce == nil || *c.Pkce
+}
+
+func (c *OauthRepositoryProviderConfig) HasLoginMode(mode string) bool {
+	for _, loginMode := range c.LoginModes {
+		if loginMode == mode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequiredScopes returns the scopes poddy needs, which personal access tokens
+// are checked against.
+func (c *OauthRepositoryProviderConfig) RequiredScopes() []string {
+	return c.settings.Scopes
+}
+
+func (c *OauthRepositoryProviderConfig) IsCredentialsProvider() bool {
+	_, ok := c.factory.(models.CredentialsRepositoryProviderFactory)
+	return ok
+}
+
+func (c *OauthRepositoryProviderConfig) GetCredentialsRepositoryProvider(credentials *models.UserCredentials) (models.RepositoryProvider, error) {
+	factory, ok := c.factory.(models.CredentialsRepositoryProviderFactory)
+	if !ok {
+		return nil, fmt.Errorf("provider type %s does not support stored credentials", c.Type)
 	}
+
+	return factory.NewRepositoryProvider(c.settings, credentials)
 }
 
 func (c *OauthRepositoryProviderConfig) GetRepositoryProvider(source oauth2.TokenSource) (models.RepositoryProvider, error) {
-	if c.Type == "gitlab" {
-		return gitlab2.GitlabApi(c.parsedBaseUrl, source), nil
+	factory, ok := c.factory.(models.OauthRepositoryProviderFactory)
+	if !ok {
+		return nil, fmt.Errorf("provider type %s does not support oauth", c.Type)
 	}
 
-	return nil, fmt.Errorf("invalid provider type: %s", c.Type)
+	return factory.NewRepositoryProvider(c.settings, source)
+}
+
+func parseOauthConfig(cfg *OauthRepositoryProviderConfig) error {
+	if len(cfg.ID) == 0 {
+		return errors.New("id is required")
+	}
+
+	cfg.factory = models.GetRepositoryProviderFactory(cfg.Type)
+	if cfg.factory == nil {
+		return fmt.Errorf("unknown provider type %q (supported types: %s)", cfg.Type, strings.Join(models.RepositoryProviderTypes(), ", "))
+	}
+
+	parsedUrl, err := url.Parse(cfg.BaseUrl)
+	if err != nil {
+		return fmt.Errorf("invalid base_url: %v", err)
+	}
+
+	cfg.settings = &models.ProviderSettings{
+		BaseUrl:       parsedUrl,
+		ApiUrl:        cfg.ApiUrl,
+		AuthEndpoint:  cfg.AuthEndpoint,
+		TokenEndpoint: cfg.TokenEndpoint,
+		Scopes:        cfg.Scopes,
+
+		ProbeRepository: cfg.ProbeRepository,
+		SshKnownHosts:   cfg.SshKnownHosts,
+
+		RequestTimeout: cfg.RequestTimeout,
+	}
+
+	if cfg.settings.RequestTimeout == 0 {
+		cfg.settings.RequestTimeout = TimeoutsProviderRequest()
+	}
+
+	cfg.RequestTimeout = cfg.settings.RequestTimeout
+
+	if err := cfg.factory.ValidateSettings(cfg.settings); err != nil {
+		return err
+	}
+
+	if err := parseLoginModes(cfg); err != nil {
+		return err
+	}
+
+	if cfg.HasLoginMode(LoginModeOauth) {
+		if len(cfg.ClientID) == 0 {
+			return errors.New("client_id is required")
+		}
+
+		cfg.OauthConfig = cfg.GetOauthConfig()
+	}
+
+	cfg.Host = parsedUrl.Host
+
+	return nil
+}
+
+func parseLoginModes(cfg *OauthRepositoryProviderConfig) error {
+	if cfg.IsCredentialsProvider() {
+		if len(cfg.LoginModes) > 0 && (len(cfg.LoginModes) != 1 || cfg.LoginModes[0] != LoginModeCredentials) {
+			return fmt.Errorf("provider type %s only supports the %s login mode", cfg.Type, LoginModeCredentials)
+		}
+
+		cfg.LoginModes = []string{LoginModeCredentials}
+		return nil
+	}
+
+	if len(cfg.LoginModes) == 0 {
+		cfg.LoginModes = []string{LoginModeOauth}
+	}
+
+	seenModes := make(map[string]bool)
+	for _, mode := range cfg.LoginModes {
+		if mode != LoginModeOauth && mode != LoginModeToken {
+			return fmt.Errorf("unsupported login mode %q (supported modes: %s, %s)", mode, LoginModeOauth, LoginModeToken)
+		}
+
+		if seenModes[mode] {
+			return fmt.Errorf("duplicate login mode: %s", mode)
+		}
+
+		seenModes[mode] = true
+	}
+
+	return nil
 }
 
 func GetOauthConfigs() ([]OauthRepositoryProviderConfig, error) {
 	providersSlice := viper.Get("providers")
+	if providersSlice == nil {
+		return []OauthRepositoryProviderConfig{}, nil
+	}
+
 	sliceLen := reflect.ValueOf(providersSlice).Len()
 
 	configSlice := make([]OauthRepositoryProviderConfig, sliceLen)
+	seenIds := make(map[string]bool)
 
 	for i := 0; i < sliceLen; i++ {
 		var cfg OauthRepositoryProviderConfig
@@ -60,15 +204,15 @@ func GetOauthConfigs() ([]OauthRepositoryProviderConfig, error) {
 			return nil, err
 		}
 
-		parsedUrl, err := url.Parse(cfg.BaseUrl)
-		if err != nil {
-			return nil, err
+		if err := parseOauthConfig(&cfg); err != nil {
+			return nil, fmt.Errorf("invalid config for provider #%d (%s): %v", i, cfg.ID, err)
 		}
 
-		cfg.parsedBaseUrl = parsedUrl
+		if seenIds[cfg.ID] {
+			return nil, fmt.Errorf("duplicate provider id: %s", cfg.ID)
+		}
 
-		cfg.OauthConfig = cfg.GetOauthConfig()
-		cfg.Host = parsedUrl.Host
+		seenIds[cfg.ID] = true
 		configSlice[i] = cfg
 	}
 
@@ -0,0 +1,321 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/oauth2"
+)
+
+type giteaApi struct {
+	client *models.ApiClient
+}
+
+func GiteaApi(baseUrl *url.URL, source oauth2.TokenSource, timeout time.Duration) *giteaApi {
+	return &giteaApi{
+		client: models.NewApiClient(baseUrl, source, timeout),
+	}
+}
+
+func (g *giteaApi) doGetRequest(ctx context.Context, path string, queryParams url.Values) (*http.Response, error) {
+	return g.client.Get(ctx, path, queryParams, nil)
+}
+
+func (g *giteaApi) getSelfUser(ctx context.Context) (*User, error) {
+	resp, err := g.doGetRequest(ctx, "/api/v1/user", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject User
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *giteaApi) getProject(ctx context.Context, slug string) (*Project, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v1/repos/%s", models.EscapeSlug(slug)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject Project
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *giteaApi) getProjectBranch(ctx context.Context, slug, branchName string) (*RepositoryBranch, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v1/repos/%s/branches/%s", models.EscapeSlug(slug), url.PathEscape(branchName)), nil)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject RepositoryBranch
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *giteaApi) getProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v1/repos/%s/raw/%s", models.EscapeSlug(slug), models.EscapeFilePath(path)),
+		url.Values{"ref": []string{ref}})
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// listProjects searches the repositories the user owns or contributes to,
+// which otherwise includes every public repository of the instance.
+func (g *giteaApi) listProjects(ctx context.Context, search string, page int) ([]Project, int, error) {
+	selfUser, err := g.getSelfUser(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get current user: %w", err)
+	}
+
+	queryParams := url.Values{
+		"uid":   []string{strconv.FormatInt(selfUser.Id, 10)},
+		"sort":  []string{"updated"},
+		"order": []string{"desc"},
+		"page":  []string{strconv.Itoa(page)},
+		"limit": []string{strconv.Itoa(models.ListPageSize)},
+	}
+
+	if len(search) > 0 {
+		queryParams.Set("q", search)
+	}
+
+	resp, err := g.doGetRequest(ctx, "/api/v1/repos/search", queryParams)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject ProjectSearchResult
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	nextPage := 0
+	if len(respObject.Data) == models.ListPageSize {
+		nextPage = page + 1
+	}
+
+	return respObject.Data, nextPage, nil
+}
+
+func (g *giteaApi) listProjectBranches(ctx context.Context, slug, search string, page int) ([]RepositoryBranch, int, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v1/repos/%s/branches", models.EscapeSlug(slug)), url.Values{
+		"page":  []string{strconv.Itoa(page)},
+		"limit": []string{strconv.Itoa(models.ListPageSize)},
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject []RepositoryBranch
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	nextPage := 0
+	if len(respObject) == models.ListPageSize {
+		nextPage = page + 1
+	}
+
+	// the branches endpoint has no search parameter, so the current page is filtered here
+	branches := make([]RepositoryBranch, 0, len(respObject))
+	for _, branch := range respObject {
+		if strings.Contains(strings.ToLower(branch.Name), strings.ToLower(search)) {
+			branches = append(branches, branch)
+		}
+	}
+
+	return branches, nextPage, nil
+}
+
+func (g *giteaApi) getMergeRequest(ctx context.Context, slug string, number int) (*PullRequest, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v1/repos/%s/pulls/%d", models.EscapeSlug(slug), number), nil)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject PullRequest
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *giteaApi) getProjectTag(ctx context.Context, slug, tagName string) (*RepositoryTag, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v1/repos/%s/tags/%s", models.EscapeSlug(slug), url.PathEscape(tagName)), nil)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject RepositoryTag
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *giteaApi) getProjectCommit(ctx context.Context, slug, sha string) (*Commit, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v1/repos/%s/git/commits/%s", models.EscapeSlug(slug), url.PathEscape(sha)), nil)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) || models.HasStatusCode(err, http.StatusUnprocessableEntity) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject Commit
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *giteaApi) GetSelfUser(ctx context.Context) (models.User, error) {
+	return g.getSelfUser(ctx)
+}
+
+func (g *giteaApi) GetProject(ctx context.Context, slug string) (models.Project, error) {
+	return g.getProject(ctx, slug)
+}
+
+func (g *giteaApi) DoesProjectBranchExist(ctx context.Context, slug, branchName string) (bool, error) {
+	branch, err := g.getProjectBranch(ctx, slug, branchName)
+	if err != nil {
+		return false, fmt.Errorf("failed to query branch: %w", err)
+	}
+
+	return branch != nil, nil
+}
+
+func (g *giteaApi) GetProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error) {
+	return g.getProjectFile(ctx, slug, ref, path)
+}
+
+func (g *giteaApi) ListProjects(ctx context.Context, search string, page int) ([]models.Project, int, error) {
+	projects, nextPage, err := g.listProjects(ctx, search, page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]models.Project, len(projects))
+	for i := range projects {
+		result[i] = &projects[i]
+	}
+
+	return result, nextPage, nil
+}
+
+func (g *giteaApi) ListBranches(ctx context.Context, slug, search string, page int) ([]models.Branch, int, error) {
+	branches, nextPage, err := g.listProjectBranches(ctx, slug, search, page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]models.Branch, len(branches))
+	for i := range branches {
+		result[i] = &branches[i]
+	}
+
+	return result, nextPage, nil
+}
+
+func (g *giteaApi) GetMergeRequest(ctx context.Context, slug string, number int) (models.MergeRequest, error) {
+	pullRequest, err := g.getMergeRequest(ctx, slug, number)
+	if err != nil || pullRequest == nil {
+		return nil, err
+	}
+
+	return pullRequest, nil
+}
+
+func (g *giteaApi) ResolveRef(ctx context.Context, slug, ref string) (*models.ResolvedRef, error) {
+	branch, err := g.getProjectBranch(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query branch: %w", err)
+	}
+	if branch != nil {
+		return &models.ResolvedRef{Name: branch.Name, Type: models.RefTypeBranch, Commit: branch.Commit.Id}, nil
+	}
+
+	tag, err := g.getProjectTag(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tag: %w", err)
+	}
+	if tag != nil {
+		return &models.ResolvedRef{Name: tag.Name, Type: models.RefTypeTag, Commit: tag.Commit.Sha}, nil
+	}
+
+	if !models.LooksLikeCommitSha(ref) {
+		return nil, nil
+	}
+
+	commit, err := g.getProjectCommit(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query commit: %w", err)
+	}
+	if commit != nil {
+		return &models.ResolvedRef{Name: ref, Type: models.RefTypeCommit, Commit: commit.Sha}, nil
+	}
+
+	return nil, nil
+}
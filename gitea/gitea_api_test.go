@@ -0,0 +1,185 @@
+package gitea
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/oauth2"
+)
+
+const testToken = "test-token"
+
+// newTestApi serves the handlers below a sub path, like Gitea instances that
+// don't run at the root of their host.
+func newTestApi(t *testing.T, handlers map[string]http.HandlerFunc) *giteaApi {
+	mux := http.NewServeMux()
+	for pattern, handler := range handlers {
+		mux.HandleFunc("/gitea"+pattern, handler)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	baseUrl, err := url.Parse(server.URL + "/gitea/")
+	if err != nil {
+		t.Fatalf("failed to parse server url: %v", err)
+	}
+
+	return GiteaApi(baseUrl, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: testToken}), 5*time.Second)
+}
+
+func TestGetSelfUser(t *testing.T) {
+	api := newTestApi(t, map[string]http.HandlerFunc{
+		"/api/v1/user": func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": 42, "login": "jdoe", "full_name": "", "email": "jdoe@example.com", "is_admin": true}`)
+		},
+	})
+
+	user, err := api.GetSelfUser(context.Background())
+	if err != nil {
+		t.Fatalf("GetSelfUser() error = %v", err)
+	}
+
+	if user.GetId() != "42" || user.GetUsername() != "jdoe" || user.GetEmail() != "jdoe@example.com" || !user.GetIsAdmin() {
+		t.Errorf("GetSelfUser() = %+v", user)
+	}
+
+	if user.GetDisplayName() != "jdoe" {
+		t.Errorf("GetDisplayName() = %q, want the login as fallback", user.GetDisplayName())
+	}
+}
+
+func TestGetProject(t *testing.T) {
+	api := newTestApi(t, map[string]http.HandlerFunc{
+		"/api/v1/repos/jdoe/poddy": func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"full_name": "jdoe/poddy", "clone_url": "https://gitea.example.com/jdoe/poddy.git", "default_branch": "main"}`)
+		},
+	})
+
+	project, err := api.GetProject(context.Background(), "jdoe/poddy")
+	if err != nil {
+		t.Fatalf("GetProject() error = %v", err)
+	}
+
+	if project.GetFullName() != "jdoe/poddy" || project.GetHttpCloneUrl() != "https://gitea.example.com/jdoe/poddy.git" || project.GetDefaultBranch() != "main" {
+		t.Errorf("GetProject() = %+v", project)
+	}
+
+	if _, err := api.GetProject(context.Background(), "jdoe/missing"); !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("GetProject() of a missing project error = %v, want %v", err, models.ErrNotFound)
+	}
+}
+
+func TestDoesProjectBranchExist(t *testing.T) {
+	api := newTestApi(t, map[string]http.HandlerFunc{
+		"/api/v1/repos/jdoe/poddy/branches/": func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.EscapedPath() {
+			case "/gitea/api/v1/repos/jdoe/poddy/branches/feature%2Flogin":
+				fmt.Fprint(w, `{"name": "feature/login"}`)
+			case "/gitea/api/v1/repos/jdoe/poddy/branches/broken":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		},
+	})
+
+	tests := []struct {
+		branch  string
+		want    bool
+		wantErr bool
+	}{
+		{branch: "feature/login", want: true},
+		{branch: "missing", want: false},
+		{branch: "broken", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.branch, func(t *testing.T) {
+			got, err := api.DoesProjectBranchExist(context.Background(), "jdoe/poddy", tt.branch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DoesProjectBranchExist() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("DoesProjectBranchExist() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetProjectFile(t *testing.T) {
+	api := newTestApi(t, map[string]http.HandlerFunc{
+		"/api/v1/repos/jdoe/poddy/raw/.poddy/config.yml": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("ref") != "main" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			fmt.Fprint(w, "ide: vscode\n")
+		},
+	})
+
+	tests := []struct {
+		name string
+		ref  string
+		path string
+		want []byte
+	}{
+		{name: "found", ref: "main", path: ".poddy/config.yml", want: []byte("ide: vscode\n")},
+		{name: "missing file", ref: "main", path: ".poddy/missing.yml", want: nil},
+		{name: "missing ref", ref: "develop", path: ".poddy/config.yml", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := api.GetProjectFile(context.Background(), "jdoe/poddy", tt.ref, tt.path)
+			if err != nil {
+				t.Fatalf("GetProjectFile() error = %v", err)
+			}
+
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("GetProjectFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListProjects(t *testing.T) {
+	api := newTestApi(t, map[string]http.HandlerFunc{
+		"/api/v1/user": func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"id": 42, "login": "jdoe"}`)
+		},
+		"/api/v1/repos/search": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("uid") != "42" {
+				t.Errorf("search uid = %q, want the id of the current user", r.URL.Query().Get("uid"))
+			}
+
+			fmt.Fprint(w, `{"ok": true, "data": [{"full_name": "jdoe/poddy"}]}`)
+		},
+	})
+
+	projects, nextPage, err := api.ListProjects(context.Background(), "poddy", 1)
+	if err != nil {
+		t.Fatalf("ListProjects() error = %v", err)
+	}
+
+	if len(projects) != 1 || projects[0].GetFullName() != "jdoe/poddy" || nextPage != 0 {
+		t.Errorf("ListProjects() = %v, %d", projects, nextPage)
+	}
+}
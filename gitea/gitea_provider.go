@@ -0,0 +1,34 @@
+package gitea
+
+import (
+	"errors"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	models.RegisterRepositoryProvider("gitea", &giteaProviderFactory{})
+}
+
+type giteaProviderFactory struct{}
+
+func (f *giteaProviderFactory) ValidateSettings(settings *models.ProviderSettings) error {
+	if settings.BaseUrl == nil || len(settings.BaseUrl.Host) == 0 {
+		return errors.New("base_url is required")
+	}
+
+	if len(settings.AuthEndpoint) == 0 {
+		settings.AuthEndpoint = "/login/oauth/authorize"
+	}
+
+	if len(settings.TokenEndpoint) == 0 {
+		settings.TokenEndpoint = "/login/oauth/access_token"
+	}
+
+	return nil
+}
+
+func (f *giteaProviderFactory) NewRepositoryProvider(settings *models.ProviderSettings, source oauth2.TokenSource) (models.RepositoryProvider, error) {
+	return GiteaApi(settings.BaseUrl, source, settings.RequestTimeout), nil
+}
@@ -0,0 +1,428 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// the largest page size of the GitHub API, used to collect repositories
+	// for a search
+	searchPageSize = 100
+	// bounds the requests of a single search to the 1000 most recently
+	// pushed repositories
+	maxSearchPages = 10
+)
+
+type githubApi struct {
+	client *models.ApiClient
+
+	// the token scopes GitHub reported along with the last user lookup
+	tokenScopes     []string
+	tokenScopesRead bool
+}
+
+func GithubApi(apiUrl *url.URL, source oauth2.TokenSource, timeout time.Duration) *githubApi {
+	return &githubApi{
+		client: models.NewApiClient(apiUrl, source, timeout),
+	}
+}
+
+// defaultApiUrl returns the REST API root belonging to the given web base url.
+// github.com serves its API from a separate host while GitHub Enterprise
+// instances expose it below /api/v3.
+func defaultApiUrl(baseUrl *url.URL) *url.URL {
+	if baseUrl.Host == "github.com" || baseUrl.Host == "www.github.com" {
+		return &url.URL{Scheme: "https", Host: "api.github.com"}
+	}
+
+	return baseUrl.ResolveReference(&url.URL{Path: "/api/v3"})
+}
+
+func (g *githubApi) doGetRequest(ctx context.Context, path string, queryParams url.Values, accept string) (*http.Response, error) {
+	return g.client.Get(ctx, path, queryParams, http.Header{"Accept": []string{accept}})
+}
+
+func (g *githubApi) getSelfUser(ctx context.Context) (*User, error) {
+	resp, err := g.doGetRequest(ctx, "/user", nil, "application/vnd.github.v3+json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject User
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	g.tokenScopes = parseTokenScopes(resp.Header)
+	g.tokenScopesRead = true
+
+	return &respObject, nil
+}
+
+// parseTokenScopes returns nil for fine-grained tokens, GitHub only reports
+// the scopes of classic tokens.
+func parseTokenScopes(header http.Header) []string {
+	if _, ok := header["X-Oauth-Scopes"]; !ok {
+		return nil
+	}
+
+	scopes := make([]string, 0)
+	for _, scope := range strings.Split(header.Get("X-OAuth-Scopes"), ",") {
+		if scope = strings.TrimSpace(scope); len(scope) > 0 {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
+
+// getTokenScopes returns the scopes of the token, which are only looked up if
+// the user hasn't been looked up yet.
+func (g *githubApi) getTokenScopes(ctx context.Context) ([]string, error) {
+	if !g.tokenScopesRead {
+		if _, err := g.getSelfUser(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return g.tokenScopes, nil
+}
+
+func (g *githubApi) getProject(ctx context.Context, slug string) (*Project, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/repos/%s", models.EscapeSlug(slug)), nil, "application/vnd.github.v3+json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject Project
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *githubApi) getProjectBranch(ctx context.Context, slug, branchName string) (*RepositoryBranch, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/repos/%s/branches/%s", models.EscapeSlug(slug), url.PathEscape(branchName)), nil, "application/vnd.github.v3+json")
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject RepositoryBranch
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *githubApi) getProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/repos/%s/contents/%s", models.EscapeSlug(slug), models.EscapeFilePath(path)),
+		url.Values{"ref": []string{ref}}, "application/vnd.github.v3.raw")
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (g *githubApi) listRepositoryPage(ctx context.Context, page, perPage int) ([]Project, error) {
+	resp, err := g.doGetRequest(ctx, "/user/repos", url.Values{
+		"sort":     []string{"pushed"},
+		"page":     []string{strconv.Itoa(page)},
+		"per_page": []string{strconv.Itoa(perPage)},
+	}, "application/vnd.github.v3+json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject []Project
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return respObject, nil
+}
+
+// listProjects lists the repositories the user has access to. /user/repos has
+// no search parameter and the search API can't find repositories the user
+// only collaborates on, so searches collect and filter the repositories
+// before paginating the matches.
+func (g *githubApi) listProjects(ctx context.Context, search string, page int) ([]Project, int, error) {
+	if len(search) == 0 {
+		projects, err := g.listRepositoryPage(ctx, page, models.ListPageSize)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		nextPage := 0
+		if len(projects) == models.ListPageSize {
+			nextPage = page + 1
+		}
+
+		return projects, nextPage, nil
+	}
+
+	matches := make([]Project, 0)
+	for repositoryPage := 1; repositoryPage <= maxSearchPages; repositoryPage++ {
+		projects, err := g.listRepositoryPage(ctx, repositoryPage, searchPageSize)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, project := range projects {
+			if strings.Contains(strings.ToLower(project.FullName), strings.ToLower(search)) {
+				matches = append(matches, project)
+			}
+		}
+
+		if len(projects) < searchPageSize {
+			break
+		}
+	}
+
+	start := (page - 1) * models.ListPageSize
+	if start >= len(matches) {
+		return []Project{}, 0, nil
+	}
+
+	end := start + models.ListPageSize
+	nextPage := page + 1
+	if end >= len(matches) {
+		end = len(matches)
+		nextPage = 0
+	}
+
+	return matches[start:end], nextPage, nil
+}
+
+func (g *githubApi) listProjectBranches(ctx context.Context, slug, search string, page int) ([]RepositoryBranch, int, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/repos/%s/branches", models.EscapeSlug(slug)), url.Values{
+		"page":     []string{strconv.Itoa(page)},
+		"per_page": []string{strconv.Itoa(models.ListPageSize)},
+	}, "application/vnd.github.v3+json")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject []RepositoryBranch
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	nextPage := 0
+	if len(respObject) == models.ListPageSize {
+		nextPage = page + 1
+	}
+
+	branches := make([]RepositoryBranch, 0, len(respObject))
+	for _, branch := range respObject {
+		if strings.Contains(strings.ToLower(branch.Name), strings.ToLower(search)) {
+			branches = append(branches, branch)
+		}
+	}
+
+	return branches, nextPage, nil
+}
+
+func (g *githubApi) getMergeRequest(ctx context.Context, slug string, number int) (*PullRequest, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/repos/%s/pulls/%d", models.EscapeSlug(slug), number), nil, "application/vnd.github.v3+json")
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject PullRequest
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *githubApi) getProjectTagRef(ctx context.Context, slug, tagName string) (*GitRef, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/repos/%s/git/ref/tags/%s", models.EscapeSlug(slug), url.PathEscape(tagName)), nil, "application/vnd.github.v3+json")
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject GitRef
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *githubApi) getProjectCommit(ctx context.Context, slug, ref string) (*Commit, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/repos/%s/commits/%s", models.EscapeSlug(slug), url.PathEscape(ref)), nil, "application/vnd.github.v3+json")
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) || models.HasStatusCode(err, http.StatusUnprocessableEntity) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject Commit
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
+}
+
+func (g *githubApi) GetSelfUser(ctx context.Context) (models.User, error) {
+	return g.getSelfUser(ctx)
+}
+
+var impliedScopes = map[string][]string{
+	"repo": {"repo:status", "repo_deployment", "public_repo", "repo:invite", "security_events"},
+	"user": {"read:user", "user:email", "user:follow"},
+}
+
+func (g *githubApi) MissingTokenScopes(ctx context.Context, required []string) ([]string, error) {
+	scopes, err := g.getTokenScopes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if scopes == nil {
+		return nil, models.ErrScopesUnverified
+	}
+
+	return models.MissingScopes(scopes, required, impliedScopes), nil
+}
+
+func (g *githubApi) GetProject(ctx context.Context, slug string) (models.Project, error) {
+	return g.getProject(ctx, slug)
+}
+
+func (g *githubApi) DoesProjectBranchExist(ctx context.Context, slug, branchName string) (bool, error) {
+	branch, err := g.getProjectBranch(ctx, slug, branchName)
+	if err != nil {
+		return false, fmt.Errorf("failed to query branch: %w", err)
+	}
+
+	return branch != nil, nil
+}
+
+func (g *githubApi) GetProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error) {
+	return g.getProjectFile(ctx, slug, ref, path)
+}
+
+func (g *githubApi) ListProjects(ctx context.Context, search string, page int) ([]models.Project, int, error) {
+	projects, nextPage, err := g.listProjects(ctx, search, page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]models.Project, len(projects))
+	for i := range projects {
+		result[i] = &projects[i]
+	}
+
+	return result, nextPage, nil
+}
+
+func (g *githubApi) ListBranches(ctx context.Context, slug, search string, page int) ([]models.Branch, int, error) {
+	branches, nextPage, err := g.listProjectBranches(ctx, slug, search, page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]models.Branch, len(branches))
+	for i := range branches {
+		result[i] = &branches[i]
+	}
+
+	return result, nextPage, nil
+}
+
+func (g *githubApi) GetMergeRequest(ctx context.Context, slug string, number int) (models.MergeRequest, error) {
+	pullRequest, err := g.getMergeRequest(ctx, slug, number)
+	if err != nil || pullRequest == nil {
+		return nil, err
+	}
+
+	return pullRequest, nil
+}
+
+func (g *githubApi) ResolveRef(ctx context.Context, slug, ref string) (*models.ResolvedRef, error) {
+	branch, err := g.getProjectBranch(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query branch: %w", err)
+	}
+	if branch != nil {
+		return &models.ResolvedRef{Name: branch.Name, Type: models.RefTypeBranch, Commit: branch.Commit.Sha}, nil
+	}
+
+	refType := models.RefTypeCommit
+
+	tagRef, err := g.getProjectTagRef(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tag: %w", err)
+	}
+
+	if tagRef != nil {
+		refType = models.RefTypeTag
+	} else if !models.LooksLikeCommitSha(ref) {
+		return nil, nil
+	}
+
+	// the commits endpoint peels annotated tags down to the commit they point at
+	commit, err := g.getProjectCommit(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query commit: %w", err)
+	}
+	if commit != nil {
+		return &models.ResolvedRef{Name: ref, Type: refType, Commit: commit.Sha}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,128 @@
+package github
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/* ================================================================================ */
+
+type User struct {
+	Id        int64  `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarUrl string `json:"avatar_url"`
+	SiteAdmin bool   `json:"site_admin"`
+}
+
+func (u *User) GetId() string {
+	return strconv.FormatInt(u.Id, 10)
+}
+
+func (u *User) GetUsername() string {
+	return u.Login
+}
+
+func (u *User) GetDisplayName() string {
+	if len(u.Name) == 0 {
+		return u.Login
+	}
+
+	return u.Name
+}
+
+func (u *User) GetEmail() string {
+	return u.Email
+}
+
+func (u *User) GetAvatarUrl() string {
+	return u.AvatarUrl
+}
+
+func (u *User) GetIsAdmin() bool {
+	return u.SiteAdmin
+}
+
+/* ================================================================================ */
+
+type Project struct {
+	FullName      string `json:"full_name"`
+	CloneUrl      string `json:"clone_url"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+func (p *Project) GetFullName() string {
+	return p.FullName
+}
+
+func (p *Project) GetHttpCloneUrl() string {
+	return p.CloneUrl
+}
+
+func (p *Project) GetDefaultBranch() string {
+	return p.DefaultBranch
+}
+
+/* ================================================================================ */
+
+type Commit struct {
+	Sha string `json:"sha"`
+}
+
+type RepositoryBranch struct {
+	Name   string `json:"name"`
+	Commit Commit `json:"commit"`
+}
+
+func (b *RepositoryBranch) GetName() string {
+	return b.Name
+}
+
+/* ================================================================================ */
+
+type PullRequestRepository struct {
+	FullName string `json:"full_name"`
+}
+
+type PullRequestRef struct {
+	Ref  string                 `json:"ref"`
+	Sha  string                 `json:"sha"`
+	Repo *PullRequestRepository `json:"repo"`
+}
+
+type PullRequest struct {
+	Number int            `json:"number"`
+	Head   PullRequestRef `json:"head"`
+	Base   PullRequestRef `json:"base"`
+}
+
+func (p *PullRequest) GetNumber() int {
+	return p.Number
+}
+
+func (p *PullRequest) GetSourceBranch() string {
+	return p.Head.Ref
+}
+
+func (p *PullRequest) GetTargetBranch() string {
+	return p.Base.Ref
+}
+
+func (p *PullRequest) GetHeadSha() string {
+	return p.Head.Sha
+}
+
+func (p *PullRequest) GetHeadRef() string {
+	return fmt.Sprintf("refs/pull/%d/head", p.Number)
+}
+
+func (p *PullRequest) IsFromFork() bool {
+	return p.Head.Repo == nil || p.Base.Repo == nil || p.Head.Repo.FullName != p.Base.Repo.FullName
+}
+
+/* ================================================================================ */
+
+type GitRef struct {
+	Ref string `json:"ref"`
+}
@@ -0,0 +1,51 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	models.RegisterRepositoryProvider("github", &githubProviderFactory{})
+}
+
+type githubProviderFactory struct{}
+
+func (f *githubProviderFactory) ValidateSettings(settings *models.ProviderSettings) error {
+	if settings.BaseUrl == nil || len(settings.BaseUrl.Host) == 0 {
+		return errors.New("base_url is required")
+	}
+
+	if len(settings.AuthEndpoint) == 0 {
+		settings.AuthEndpoint = "/login/oauth/authorize"
+	}
+
+	if len(settings.TokenEndpoint) == 0 {
+		settings.TokenEndpoint = "/login/oauth/access_token"
+	}
+
+	if len(settings.Scopes) == 0 {
+		settings.Scopes = []string{"repo", "read:user", "user:email"}
+	}
+
+	if len(settings.ApiUrl) == 0 {
+		settings.ApiUrl = defaultApiUrl(settings.BaseUrl).String()
+	} else if _, err := url.Parse(settings.ApiUrl); err != nil {
+		return fmt.Errorf("invalid api_url: %v", err)
+	}
+
+	return nil
+}
+
+func (f *githubProviderFactory) NewRepositoryProvider(settings *models.ProviderSettings, source oauth2.TokenSource) (models.RepositoryProvider, error) {
+	apiUrl, err := url.Parse(settings.ApiUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid api_url: %v", err)
+	}
+
+	return GithubApi(apiUrl, source, settings.RequestTimeout), nil
+}
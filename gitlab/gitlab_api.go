@@ -1,154 +1,397 @@
 package gitlab
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/dogboy21/poddy/models"
 	"golang.org/x/oauth2"
 )
 
+const (
+	maxRequestAttempts  = 4
+	initialRetryBackoff = 500 * time.Millisecond
+	maxRetryWait        = 30 * time.Second
+)
+
 type gitlabApi struct {
-	baseUrl   *url.URL
-	transport http.RoundTripper
+	client *models.ApiClient
+	// waits between retries, replaced in tests
+	wait func(ctx context.Context, duration time.Duration) error
 }
 
-func GitlabApi(baseUrl *url.URL, source oauth2.TokenSource) *gitlabApi {
+func GitlabApi(baseUrl *url.URL, source oauth2.TokenSource, timeout time.Duration) *gitlabApi {
 	return &gitlabApi{
-		baseUrl: baseUrl,
-		transport: &oauth2.Transport{
-			Source: source,
-			Base:   http.DefaultTransport,
-		},
+		client: models.NewApiClient(baseUrl, source, timeout),
+		wait:   waitForRetry,
+	}
+}
+
+func waitForRetry(ctx context.Context, duration time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(duration):
+		return nil
+	}
+}
+
+// doGetRequest retries rate limited and failed requests with an exponential
+// backoff, unless GitLab tells us how long to wait through its headers.
+func (g *gitlabApi) doGetRequest(ctx context.Context, path string, queryParams url.Values) (*http.Response, error) {
+	backoff := initialRetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		resp, err := g.client.Get(ctx, path, queryParams, nil)
+		if err == nil {
+			return resp, nil
+		}
+
+		var providerError *models.ProviderError
+		if !errors.As(err, &providerError) {
+			return nil, err
+		}
+
+		if attempt >= maxRequestAttempts || !models.IsRetryable(providerError) {
+			return nil, providerError
+		}
+
+		wait := backoff
+		if providerError.RetryAfter > 0 {
+			wait = providerError.RetryAfter
+		}
+
+		if wait > maxRetryWait {
+			return nil, providerError
+		}
+
+		if err := g.wait(ctx, wait); err != nil {
+			return nil, models.NewTransportError(err)
+		}
+
+		backoff *= 2
 	}
 }
 
-func (g *gitlabApi) getSubUrl(path string, queryParams url.Values) string {
-	pathUnescaped, err := url.PathUnescape(path)
+func (g *gitlabApi) getSelfUser(ctx context.Context) (*User, error) {
+	resp, err := g.doGetRequest(ctx, "/api/v4/user", nil)
 	if err != nil {
-		log.Fatalf("failed to unescape path: %v\n", err)
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 
-	query := ""
-	if queryParams != nil {
-		query = queryParams.Encode()
+	defer resp.Body.Close()
+
+	var respObject User
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
 	}
 
-	return g.baseUrl.ResolveReference(&url.URL{
-		Path:     pathUnescaped,
-		RawPath:  path,
-		RawQuery: query,
-	}).String()
+	return &respObject, nil
 }
 
-func (g *gitlabApi) doGetRequest(path string, queryParams url.Values) (*http.Response, error) {
-	req, err := http.NewRequest("GET", g.getSubUrl(path, queryParams), nil)
+// getTokenScopes returns nil on GitLab versions that can't introspect
+// personal access tokens yet.
+func (g *gitlabApi) getTokenScopes(ctx context.Context) ([]string, error) {
+	resp, err := g.doGetRequest(ctx, "/api/v4/personal_access_tokens/self", nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 
-	resp, err := g.transport.RoundTrip(req)
+	defer resp.Body.Close()
+
+	var respObject PersonalAccessToken
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return respObject.Scopes, nil
+}
+
+func (g *gitlabApi) getProject(ctx context.Context, slug string) (*Project, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v4/projects/%s", url.PathEscape(slug)), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get response: %v", err)
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return nil, fmt.Errorf("invalid status code: %d", resp.StatusCode)
+	defer resp.Body.Close()
+
+	var respObject Project
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
 	}
 
-	return resp, nil
+	return &respObject, nil
 }
 
-func (g *gitlabApi) getSelfUser() (*User, error) {
-	resp, err := g.doGetRequest("/api/v4/user", nil)
+func (g *gitlabApi) getProjectBranch(ctx context.Context, slug, branchName string) (*RepositoryBranch, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v4/projects/%s/repository/branches/%s", url.PathEscape(slug), url.PathEscape(branchName)), nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %v", err)
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 
 	defer resp.Body.Close()
 
-	var respObject User
+	var respObject RepositoryBranch
 	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
-		return nil, fmt.Errorf("failed to decode response data: %v", err)
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
 	}
 
 	return &respObject, nil
 }
 
-func (g *gitlabApi) getProject(slug string) (*Project, error) {
-	resp, err := g.doGetRequest(fmt.Sprintf("/api/v4/projects/%s", url.PathEscape(slug)), nil)
+func (g *gitlabApi) getProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v4/projects/%s/repository/files/%s/raw", url.PathEscape(slug), url.PathEscape(path)),
+		url.Values{"ref": []string{ref}})
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %v", err)
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 
 	defer resp.Body.Close()
 
-	var respObject Project
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (g *gitlabApi) listProjects(ctx context.Context, search string, page int) ([]Project, int, error) {
+	queryParams := url.Values{
+		"membership": []string{"true"},
+		"simple":     []string{"true"},
+		"order_by":   []string{"last_activity_at"},
+		"page":       []string{strconv.Itoa(page)},
+		"per_page":   []string{strconv.Itoa(models.ListPageSize)},
+	}
+
+	if len(search) > 0 {
+		queryParams.Set("search", search)
+	}
+
+	resp, err := g.doGetRequest(ctx, "/api/v4/projects", queryParams)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject []Project
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	nextPage, _ := strconv.Atoi(resp.Header.Get("X-Next-Page"))
+
+	return respObject, nextPage, nil
+}
+
+func (g *gitlabApi) listProjectBranches(ctx context.Context, slug, search string, page int) ([]RepositoryBranch, int, error) {
+	queryParams := url.Values{
+		"page":     []string{strconv.Itoa(page)},
+		"per_page": []string{strconv.Itoa(models.ListPageSize)},
+	}
+
+	if len(search) > 0 {
+		queryParams.Set("search", search)
+	}
+
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v4/projects/%s/repository/branches", url.PathEscape(slug)), queryParams)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject []RepositoryBranch
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	nextPage, _ := strconv.Atoi(resp.Header.Get("X-Next-Page"))
+
+	return respObject, nextPage, nil
+}
+
+func (g *gitlabApi) getMergeRequest(ctx context.Context, slug string, number int) (*MergeRequest, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v4/projects/%s/merge_requests/%d", url.PathEscape(slug), number), nil)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var respObject MergeRequest
 	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
-		return nil, fmt.Errorf("failed to decode response data: %v", err)
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
 	}
 
 	return &respObject, nil
 }
 
-func (g *gitlabApi) getProjectBranch(slug, branchName string) (*RepositoryBranch, error) {
-	resp, err := g.doGetRequest(fmt.Sprintf("/api/v4/projects/%s/repository/branches/%s", url.PathEscape(slug), url.PathEscape(branchName)), nil)
+func (g *gitlabApi) getProjectTag(ctx context.Context, slug, tagName string) (*RepositoryTag, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v4/projects/%s/repository/tags/%s", url.PathEscape(slug), url.PathEscape(tagName)), nil)
 	if err != nil {
-		if err.Error() == "invalid status code: 404" {
+		if errors.Is(err, models.ErrNotFound) {
 			return nil, nil
 		}
 
-		return nil, fmt.Errorf("failed to execute request: %v", err)
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 
 	defer resp.Body.Close()
 
-	var respObject RepositoryBranch
+	var respObject RepositoryTag
 	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
-		return nil, fmt.Errorf("failed to decode response data: %v", err)
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
 	}
 
 	return &respObject, nil
 }
 
-func (g *gitlabApi) getProjectFile(slug, ref, path string) ([]byte, error) {
-	resp, err := g.doGetRequest(fmt.Sprintf("/api/v4/projects/%s/repository/files/%s/raw", url.PathEscape(slug), url.PathEscape(path)),
-		url.Values{"ref": []string{ref}})
+func (g *gitlabApi) getProjectCommit(ctx context.Context, slug, sha string) (*Commit, error) {
+	resp, err := g.doGetRequest(ctx, fmt.Sprintf("/api/v4/projects/%s/repository/commits/%s", url.PathEscape(slug), url.PathEscape(sha)), nil)
 	if err != nil {
-		if err.Error() == "invalid status code: 404" {
+		if errors.Is(err, models.ErrNotFound) {
 			return nil, nil
 		}
 
-		return nil, fmt.Errorf("failed to execute request: %v", err)
+		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
 
 	defer resp.Body.Close()
 
-	return ioutil.ReadAll(resp.Body)
+	var respObject Commit
+	if err := json.NewDecoder(resp.Body).Decode(&respObject); err != nil {
+		return nil, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return &respObject, nil
 }
 
-func (g *gitlabApi) GetSelfUser() (models.User, error) {
-	return g.getSelfUser()
+func (g *gitlabApi) GetSelfUser(ctx context.Context) (models.User, error) {
+	return g.getSelfUser(ctx)
 }
 
-func (g *gitlabApi) GetProject(slug string) (models.Project, error) {
-	return g.getProject(slug)
+var impliedScopes = map[string][]string{
+	"api":              {"read_api", "read_user", "read_repository", "write_repository"},
+	"read_api":         {"read_user"},
+	"write_repository": {"read_repository"},
 }
 
-func (g *gitlabApi) DoesProjectBranchExist(slug, branchName string) (bool, error) {
-	_, err := g.getProjectBranch(slug, branchName)
+func (g *gitlabApi) MissingTokenScopes(ctx context.Context, required []string) ([]string, error) {
+	scopes, err := g.getTokenScopes(ctx)
 	if err != nil {
-		return false, fmt.Errorf("failed to query branch: %v", err)
+		return nil, err
+	}
+
+	if scopes == nil {
+		return nil, models.ErrScopesUnverified
 	}
 
-	return true, nil
+	return models.MissingScopes(scopes, required, impliedScopes), nil
+}
+
+func (g *gitlabApi) GetProject(ctx context.Context, slug string) (models.Project, error) {
+	return g.getProject(ctx, slug)
 }
 
-func (g *gitlabApi) GetProjectFile(slug, ref, path string) ([]byte, error) {
-	return g.getProjectFile(slug, ref, path)
+func (g *gitlabApi) DoesProjectBranchExist(ctx context.Context, slug, branchName string) (bool, error) {
+	branch, err := g.getProjectBranch(ctx, slug, branchName)
+	if err != nil {
+		return false, fmt.Errorf("failed to query branch: %w", err)
+	}
+
+	return branch != nil, nil
+}
+
+func (g *gitlabApi) GetProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error) {
+	return g.getProjectFile(ctx, slug, ref, path)
+}
+
+func (g *gitlabApi) ListProjects(ctx context.Context, search string, page int) ([]models.Project, int, error) {
+	projects, nextPage, err := g.listProjects(ctx, search, page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]models.Project, len(projects))
+	for i := range projects {
+		result[i] = &projects[i]
+	}
+
+	return result, nextPage, nil
+}
+
+func (g *gitlabApi) ListBranches(ctx context.Context, slug, search string, page int) ([]models.Branch, int, error) {
+	branches, nextPage, err := g.listProjectBranches(ctx, slug, search, page)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	result := make([]models.Branch, len(branches))
+	for i := range branches {
+		result[i] = &branches[i]
+	}
+
+	return result, nextPage, nil
+}
+
+func (g *gitlabApi) GetMergeRequest(ctx context.Context, slug string, number int) (models.MergeRequest, error) {
+	mergeRequest, err := g.getMergeRequest(ctx, slug, number)
+	if err != nil || mergeRequest == nil {
+		return nil, err
+	}
+
+	return mergeRequest, nil
+}
+
+func (g *gitlabApi) ResolveRef(ctx context.Context, slug, ref string) (*models.ResolvedRef, error) {
+	branch, err := g.getProjectBranch(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query branch: %w", err)
+	}
+	if branch != nil {
+		return &models.ResolvedRef{Name: branch.Name, Type: models.RefTypeBranch, Commit: branch.Commit.Id}, nil
+	}
+
+	tag, err := g.getProjectTag(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tag: %w", err)
+	}
+	if tag != nil {
+		return &models.ResolvedRef{Name: tag.Name, Type: models.RefTypeTag, Commit: tag.Commit.Id}, nil
+	}
+
+	if !models.LooksLikeCommitSha(ref) {
+		return nil, nil
+	}
+
+	commit, err := g.getProjectCommit(ctx, slug, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query commit: %w", err)
+	}
+	if commit != nil {
+		return &models.ResolvedRef{Name: ref, Type: models.RefTypeCommit, Commit: commit.Id}, nil
+	}
+
+	return nil, nil
 }
@@ -1,8 +1,14 @@
 package gitlab
 
+import (
+	"fmt"
+	"strconv"
+)
+
 /* ================================================================================ */
 
 type User struct {
+	Id        int64  `json:"id"`
 	Username  string `json:"username"`
 	Name      string `json:"name"`
 	Email     string `json:"email"`
@@ -10,6 +16,10 @@ type User struct {
 	IsAdmin   bool   `json:"is_admin"`
 }
 
+func (u *User) GetId() string {
+	return strconv.FormatInt(u.Id, 10)
+}
+
 func (u *User) GetUsername() string {
 	return u.Username
 }
@@ -52,6 +62,61 @@ func (p *Project) GetDefaultBranch() string {
 
 /* ================================================================================ */
 
+type Commit struct {
+	Id string `json:"id"`
+}
+
 type RepositoryBranch struct {
-	Name string `json:"name"`
+	Name   string `json:"name"`
+	Commit Commit `json:"commit"`
+}
+
+func (b *RepositoryBranch) GetName() string {
+	return b.Name
+}
+
+/* ================================================================================ */
+
+type MergeRequest struct {
+	Iid             int    `json:"iid"`
+	SourceBranch    string `json:"source_branch"`
+	TargetBranch    string `json:"target_branch"`
+	SourceProjectId int    `json:"source_project_id"`
+	TargetProjectId int    `json:"target_project_id"`
+	Sha             string `json:"sha"`
+}
+
+func (m *MergeRequest) GetNumber() int {
+	return m.Iid
+}
+
+func (m *MergeRequest) GetSourceBranch() string {
+	return m.SourceBranch
+}
+
+func (m *MergeRequest) GetTargetBranch() string {
+	return m.TargetBranch
+}
+
+func (m *MergeRequest) GetHeadSha() string {
+	return m.Sha
+}
+
+func (m *MergeRequest) GetHeadRef() string {
+	return fmt.Sprintf("refs/merge-requests/%d/head", m.Iid)
+}
+
+func (m *MergeRequest) IsFromFork() bool {
+	return m.SourceProjectId != m.TargetProjectId
+}
+
+/* ================================================================================ */
+
+type RepositoryTag struct {
+	Name   string `json:"name"`
+	Commit Commit `json:"commit"`
+}
+
+type PersonalAccessToken struct {
+	Scopes []string `json:"scopes"`
 }
@@ -0,0 +1,166 @@
+package gitlab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/oauth2"
+)
+
+type testResponse struct {
+	statusCode int
+	header     http.Header
+}
+
+// newTestApi answers the requests with the responses in order and records the
+// waits between the attempts instead of sleeping.
+func newTestApi(t *testing.T, responses []testResponse, waitErr error) (*gitlabApi, *int, *[]time.Duration) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response := responses[len(responses)-1]
+		if attempts < len(responses) {
+			response = responses[attempts]
+		}
+		attempts++
+
+		for key, values := range response.header {
+			w.Header()[key] = values
+		}
+
+		w.WriteHeader(response.statusCode)
+		if response.statusCode == http.StatusOK {
+			fmt.Fprint(w, `{"id": 1, "username": "jdoe"}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	baseUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("failed to parse server url: %v", err)
+	}
+
+	api := GitlabApi(baseUrl, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test-token"}), 5*time.Second)
+
+	waits := make([]time.Duration, 0)
+	api.wait = func(ctx context.Context, duration time.Duration) error {
+		waits = append(waits, duration)
+		return waitErr
+	}
+
+	return api, &attempts, &waits
+}
+
+func TestDoGetRequestRetries(t *testing.T) {
+	ok := testResponse{statusCode: http.StatusOK}
+	unavailable := testResponse{statusCode: http.StatusServiceUnavailable}
+
+	tests := []struct {
+		name         string
+		responses    []testResponse
+		waitErr      error
+		wantAttempts int
+		wantWaits    []time.Duration
+		wantErr      error
+	}{
+		{
+			name:         "success",
+			responses:    []testResponse{ok},
+			wantAttempts: 1,
+			wantWaits:    []time.Duration{},
+		},
+		{
+			name:         "recovers with backoff",
+			responses:    []testResponse{unavailable, unavailable, ok},
+			wantAttempts: 3,
+			wantWaits:    []time.Duration{500 * time.Millisecond, time.Second},
+		},
+		{
+			name:         "gives up after the last attempt",
+			responses:    []testResponse{unavailable},
+			wantAttempts: maxRequestAttempts,
+			wantWaits:    []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second},
+			wantErr:      models.ErrUpstreamUnavailable,
+		},
+		{
+			name:         "honours retry-after",
+			responses:    []testResponse{{statusCode: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"7"}}}, ok},
+			wantAttempts: 2,
+			wantWaits:    []time.Duration{7 * time.Second},
+		},
+		{
+			name:         "retry-after above the maximum wait",
+			responses:    []testResponse{{statusCode: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"60"}}}},
+			wantAttempts: 1,
+			wantWaits:    []time.Duration{},
+			wantErr:      models.ErrRateLimited,
+		},
+		{
+			name:         "not retryable",
+			responses:    []testResponse{{statusCode: http.StatusNotFound}},
+			wantAttempts: 1,
+			wantWaits:    []time.Duration{},
+			wantErr:      models.ErrNotFound,
+		},
+		{
+			name:         "canceled while waiting",
+			responses:    []testResponse{unavailable},
+			waitErr:      context.Canceled,
+			wantAttempts: 1,
+			wantWaits:    []time.Duration{500 * time.Millisecond},
+			wantErr:      context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api, attempts, waits := newTestApi(t, tt.responses, tt.waitErr)
+
+			resp, err := api.doGetRequest(context.Background(), "/api/v4/user", nil)
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			if (tt.wantErr == nil) != (err == nil) || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Errorf("doGetRequest() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if *attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", *attempts, tt.wantAttempts)
+			}
+
+			if !reflect.DeepEqual(*waits, tt.wantWaits) {
+				t.Errorf("waits = %v, want %v", *waits, tt.wantWaits)
+			}
+		})
+	}
+}
+
+func TestDoGetRequestRateLimitReset(t *testing.T) {
+	reset := time.Now().Add(20 * time.Second).Unix()
+	api, attempts, waits := newTestApi(t, []testResponse{
+		{statusCode: http.StatusTooManyRequests, header: http.Header{"Ratelimit-Reset": {fmt.Sprint(reset)}}},
+		{statusCode: http.StatusOK},
+	}, nil)
+
+	resp, err := api.doGetRequest(context.Background(), "/api/v4/user", nil)
+	if err != nil {
+		t.Fatalf("doGetRequest() error = %v", err)
+	}
+	resp.Body.Close()
+
+	if *attempts != 2 || len(*waits) != 1 {
+		t.Fatalf("attempts = %d, waits = %v", *attempts, *waits)
+	}
+
+	if wait := (*waits)[0]; wait <= 18*time.Second || wait > 20*time.Second {
+		t.Errorf("wait = %v, want the time until the reset", wait)
+	}
+}
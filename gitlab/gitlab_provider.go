@@ -0,0 +1,38 @@
+package gitlab
+
+import (
+	"errors"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/oauth2"
+)
+
+func init() {
+	models.RegisterRepositoryProvider("gitlab", &gitlabProviderFactory{})
+}
+
+type gitlabProviderFactory struct{}
+
+func (f *gitlabProviderFactory) ValidateSettings(settings *models.ProviderSettings) error {
+	if settings.BaseUrl == nil || len(settings.BaseUrl.Host) == 0 {
+		return errors.New("base_url is required")
+	}
+
+	if len(settings.AuthEndpoint) == 0 {
+		settings.AuthEndpoint = "/oauth/authorize"
+	}
+
+	if len(settings.TokenEndpoint) == 0 {
+		settings.TokenEndpoint = "/oauth/token"
+	}
+
+	if len(settings.Scopes) == 0 {
+		settings.Scopes = []string{"read_user", "read_api", "write_repository"}
+	}
+
+	return nil
+}
+
+func (f *gitlabProviderFactory) NewRepositoryProvider(settings *models.ProviderSettings, source oauth2.TokenSource) (models.RepositoryProvider, error) {
+	return GitlabApi(settings.BaseUrl, source, settings.RequestTimeout), nil
+}
@@ -0,0 +1,360 @@
+package gitremote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/crypto/ssh"
+)
+
+type gitRemote struct {
+	baseUrl         *url.URL
+	probeRepository string
+	knownHosts      string
+	credentials     *models.UserCredentials
+	timeout         time.Duration
+}
+
+func GitRemote(baseUrl *url.URL, probeRepository, knownHosts string, credentials *models.UserCredentials, timeout time.Duration) *gitRemote {
+	return &gitRemote{
+		baseUrl:         baseUrl,
+		probeRepository: probeRepository,
+		knownHosts:      knownHosts,
+		credentials:     credentials,
+		timeout:         timeout,
+	}
+}
+
+// getRemoteUrl returns the url of the repository. Ssh remotes without a fixed
+// user in the base url are logged in to as the user, so the ssh server checks
+// the key against that user.
+func (g *gitRemote) getRemoteUrl(slug string) string {
+	remoteUrl := *g.baseUrl
+	remoteUrl.Path = strings.TrimSuffix(remoteUrl.Path, "/") + "/" + strings.TrimPrefix(slug, "/")
+	remoteUrl.RawPath = ""
+
+	if g.isSsh() && g.baseUrl.User == nil && len(g.credentials.Username) > 0 {
+		remoteUrl.User = url.User(g.credentials.Username)
+	}
+
+	return remoteUrl.String()
+}
+
+func (g *gitRemote) isSsh() bool {
+	return g.baseUrl.Scheme == "ssh"
+}
+
+// sshKeyFingerprint returns the SHA256 fingerprint of the public key of the
+// stored ssh key.
+func (g *gitRemote) sshKeyFingerprint() (string, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(strings.TrimSpace(g.credentials.SshPrivateKey) + "\n"))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse ssh key: %w", err)
+	}
+
+	return ssh.FingerprintSHA256(signer.PublicKey()), nil
+}
+
+// runGit executes git with the stored credentials applied. Passwords are
+// handed over through the GIT_CONFIG_* environment instead of the command
+// line so they don't show up in the process list.
+func (g *gitRemote) runGit(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	tmpDir, err := ioutil.TempDir("", "poddy-git-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	env := append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"HOME="+tmpDir,
+	)
+
+	if g.isSsh() {
+		if len(g.credentials.SshPrivateKey) == 0 {
+			return nil, fmt.Errorf("no ssh key configured for remote %s", g.baseUrl.Host)
+		}
+
+		keyFile := filepath.Join(tmpDir, "id_poddy")
+		if err := ioutil.WriteFile(keyFile, []byte(strings.TrimSpace(g.credentials.SshPrivateKey)+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write ssh key: %w", err)
+		}
+
+		// without configured known hosts the host key can't be verified and
+		// is accepted on first use, which happens on every run here
+		knownHostsFile := filepath.Join(tmpDir, "known_hosts")
+		hostKeyChecking := "accept-new"
+		if len(g.knownHosts) > 0 {
+			if err := ioutil.WriteFile(knownHostsFile, []byte(strings.TrimSpace(g.knownHosts)+"\n"), 0600); err != nil {
+				return nil, fmt.Errorf("failed to write known hosts: %w", err)
+			}
+
+			hostKeyChecking = "yes"
+		}
+
+		env = append(env, fmt.Sprintf("GIT_SSH_COMMAND=ssh -i %s -o IdentitiesOnly=yes -o BatchMode=yes -o StrictHostKeyChecking=%s -o UserKnownHostsFile=%s",
+			keyFile, hostKeyChecking, knownHostsFile))
+	} else if len(g.credentials.Username) > 0 || len(g.credentials.Password) > 0 {
+		basicAuth := base64.StdEncoding.EncodeToString([]byte(g.credentials.Username + ":" + g.credentials.Password))
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+basicAuth,
+		)
+	}
+
+	if g.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, g.timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, models.NewTransportError(fmt.Errorf("git %s failed: %w", args[0], ctx.Err()))
+		}
+
+		return nil, classifyGitError(fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String())))
+	}
+
+	return output, nil
+}
+
+// classifyGitError maps the error messages of the git cli to the provider
+// errors, as there is no status code to go by.
+func classifyGitError(err error) error {
+	message := strings.ToLower(err.Error())
+
+	containsAny := func(substrings ...string) bool {
+		for _, substring := range substrings {
+			if strings.Contains(message, substring) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	switch {
+	case containsAny("authentication failed", "could not read username", "could not read password", "permission denied", "terminal prompts disabled", "returned error: 401"):
+		return &models.ProviderError{Kind: models.ErrUnauthorized, Err: err}
+	case containsAny("returned error: 403"):
+		return &models.ProviderError{Kind: models.ErrForbidden, Err: err}
+	case containsAny("repository not found", "does not appear to be a git repository", "couldn't find remote ref", "not our ref", "returned error: 404"):
+		return &models.ProviderError{Kind: models.ErrNotFound, Err: err}
+	case containsAny("returned error: 429"):
+		return &models.ProviderError{Kind: models.ErrRateLimited, Err: err}
+	case containsAny("could not resolve host", "connection refused", "timed out", "connection reset", "returned error: 5"):
+		return &models.ProviderError{Kind: models.ErrUpstreamUnavailable, Err: err}
+	}
+
+	return err
+}
+
+func (g *gitRemote) lsRemote(ctx context.Context, slug string, args ...string) ([][]string, error) {
+	output, err := g.runGit(ctx, "", append([]string{"ls-remote"}, append(args, g.getRemoteUrl(slug))...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([][]string, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) == 2 {
+			lines = append(lines, fields)
+		}
+	}
+
+	return lines, nil
+}
+
+func (g *gitRemote) getProject(ctx context.Context, slug string) (*Project, error) {
+	lines, err := g.lsRemote(ctx, slug, "--symref")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote refs: %w", err)
+	}
+
+	project := &Project{
+		Slug:     slug,
+		CloneUrl: g.getRemoteUrl(slug),
+	}
+
+	for _, line := range lines {
+		if line[1] == "HEAD" && strings.HasPrefix(line[0], "ref: refs/heads/") {
+			project.DefaultBranch = strings.TrimPrefix(line[0], "ref: refs/heads/")
+			break
+		}
+	}
+
+	return project, nil
+}
+
+func (g *gitRemote) getProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error) {
+	tmpDir, err := ioutil.TempDir("", "poddy-fetch-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	if _, err := g.runGit(ctx, tmpDir, "init", "--quiet"); err != nil {
+		return nil, err
+	}
+
+	if _, err := g.runGit(ctx, tmpDir, "fetch", "--quiet", "--depth", "1", "--no-tags", g.getRemoteUrl(slug), ref); err != nil {
+		return nil, err
+	}
+
+	if _, err := g.runGit(ctx, tmpDir, "cat-file", "-e", "FETCH_HEAD:"+strings.TrimPrefix(path, "/")); err != nil {
+		return nil, nil
+	}
+
+	return g.runGit(ctx, tmpDir, "show", "FETCH_HEAD:"+strings.TrimPrefix(path, "/"))
+}
+
+// GetSelfUser checks the credentials by listing the probe repository, which
+// can't be read without logging in. The user is identified by what the remote
+// verified: the username of http remotes and of ssh remotes that are logged
+// in to as the user, or the key of ssh remotes with a shared user like git.
+func (g *gitRemote) GetSelfUser(ctx context.Context) (models.User, error) {
+	if _, err := g.lsRemote(ctx, g.probeRepository, "--heads"); err != nil {
+		if errors.Is(err, models.ErrNotFound) || errors.Is(err, models.ErrForbidden) {
+			err = &models.ProviderError{Kind: models.ErrUnauthorized, Err: err}
+		}
+
+		return nil, fmt.Errorf("failed to verify credentials: %w", err)
+	}
+
+	user := &User{
+		Id:          g.credentials.Username,
+		Username:    g.credentials.Username,
+		DisplayName: g.credentials.DisplayName,
+		Email:       g.credentials.Email,
+	}
+
+	if g.isSsh() && g.baseUrl.User != nil {
+		fingerprint, err := g.sshKeyFingerprint()
+		if err != nil {
+			return nil, err
+		}
+
+		user.Id = fingerprint
+		user.Username = fingerprint
+	}
+
+	return user, nil
+}
+
+func (g *gitRemote) GetProject(ctx context.Context, slug string) (models.Project, error) {
+	return g.getProject(ctx, slug)
+}
+
+func (g *gitRemote) DoesProjectBranchExist(ctx context.Context, slug, branchName string) (bool, error) {
+	lines, err := g.lsRemote(ctx, slug, "--heads")
+	if err != nil {
+		return false, fmt.Errorf("failed to query branch: %w", err)
+	}
+
+	for _, line := range lines {
+		if line[1] == "refs/heads/"+branchName {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (g *gitRemote) GetProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error) {
+	return g.getProjectFile(ctx, slug, ref, path)
+}
+
+func (g *gitRemote) ListProjects(ctx context.Context, search string, page int) ([]models.Project, int, error) {
+	return []models.Project{}, 0, nil
+}
+
+func (g *gitRemote) ListBranches(ctx context.Context, slug, search string, page int) ([]models.Branch, int, error) {
+	lines, err := g.lsRemote(ctx, slug, "--heads")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	branches := make([]models.Branch, 0, len(lines))
+	for _, line := range lines {
+		branchName := strings.TrimPrefix(line[1], "refs/heads/")
+		if strings.Contains(strings.ToLower(branchName), strings.ToLower(search)) {
+			branches = append(branches, &Branch{Name: branchName})
+		}
+	}
+
+	start := (page - 1) * models.ListPageSize
+	if start >= len(branches) {
+		return []models.Branch{}, 0, nil
+	}
+
+	end := start + models.ListPageSize
+	nextPage := page + 1
+	if end >= len(branches) {
+		end = len(branches)
+		nextPage = 0
+	}
+
+	return branches[start:end], nextPage, nil
+}
+
+func (g *gitRemote) GetMergeRequest(ctx context.Context, slug string, number int) (models.MergeRequest, error) {
+	return nil, fmt.Errorf("plain git remotes have no merge requests: %w", models.ErrUnsupported)
+}
+
+func (g *gitRemote) ResolveRef(ctx context.Context, slug, ref string) (*models.ResolvedRef, error) {
+	lines, err := g.lsRemote(ctx, slug, "--heads", "--tags")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote refs: %w", err)
+	}
+
+	var tagCommit string
+	for _, line := range lines {
+		switch line[1] {
+		case "refs/heads/" + ref:
+			return &models.ResolvedRef{Name: ref, Type: models.RefTypeBranch, Commit: line[0]}, nil
+		case "refs/tags/" + ref + "^{}":
+			return &models.ResolvedRef{Name: ref, Type: models.RefTypeTag, Commit: line[0]}, nil
+		case "refs/tags/" + ref:
+			tagCommit = line[0]
+		}
+	}
+
+	if len(tagCommit) > 0 {
+		return &models.ResolvedRef{Name: ref, Type: models.RefTypeTag, Commit: tagCommit}, nil
+	}
+
+	// abbreviated shas can't be expanded without fetching the whole history
+	if len(ref) == 40 && models.LooksLikeCommitSha(ref) {
+		return &models.ResolvedRef{Name: ref, Type: models.RefTypeCommit, Commit: strings.ToLower(ref)}, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,70 @@
+package gitremote
+
+/* ================================================================================ */
+
+type User struct {
+	Id          string
+	Username    string
+	DisplayName string
+	Email       string
+}
+
+// GetId returns the username or ssh key fingerprint the remote verified, as
+// plain git remotes don't know about users.
+func (u *User) GetId() string {
+	return u.Id
+}
+
+func (u *User) GetUsername() string {
+	return u.Username
+}
+
+func (u *User) GetDisplayName() string {
+	if len(u.DisplayName) == 0 {
+		return u.Username
+	}
+
+	return u.DisplayName
+}
+
+func (u *User) GetEmail() string {
+	return u.Email
+}
+
+func (u *User) GetAvatarUrl() string {
+	return ""
+}
+
+func (u *User) GetIsAdmin() bool {
+	return false
+}
+
+/* ================================================================================ */
+
+type Project struct {
+	Slug          string
+	CloneUrl      string
+	DefaultBranch string
+}
+
+func (p *Project) GetFullName() string {
+	return p.Slug
+}
+
+func (p *Project) GetHttpCloneUrl() string {
+	return p.CloneUrl
+}
+
+func (p *Project) GetDefaultBranch() string {
+	return p.DefaultBranch
+}
+
+/* ================================================================================ */
+
+type Branch struct {
+	Name string
+}
+
+func (b *Branch) GetName() string {
+	return b.Name
+}
@@ -0,0 +1,66 @@
+package gitremote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/dogboy21/poddy/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	models.RegisterRepositoryProvider("git", &gitRemoteProviderFactory{})
+}
+
+type gitRemoteProviderFactory struct{}
+
+func (f *gitRemoteProviderFactory) ValidateSettings(settings *models.ProviderSettings) error {
+	if settings.BaseUrl == nil {
+		return errors.New("base_url is required")
+	}
+
+	// file remotes are readable without credentials, so logins can't be
+	// verified against them
+	switch settings.BaseUrl.Scheme {
+	case "http", "https", "ssh":
+		if len(settings.BaseUrl.Host) == 0 {
+			return errors.New("base_url must contain a host")
+		}
+	default:
+		return fmt.Errorf("unsupported base_url scheme: %s", settings.BaseUrl.Scheme)
+	}
+
+	if len(settings.ProbeRepository) == 0 {
+		return errors.New("probe_repository is required to verify credentials")
+	}
+
+	if settings.BaseUrl.Scheme == "ssh" {
+		if len(settings.SshKnownHosts) == 0 {
+			log.Printf("WARNING: ssh_known_hosts isn't set for %s, the host key of the remote is accepted on first use without being verified\n", settings.BaseUrl.Host)
+		} else if err := validateKnownHosts(settings.SshKnownHosts); err != nil {
+			return fmt.Errorf("invalid ssh_known_hosts: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (f *gitRemoteProviderFactory) NewRepositoryProvider(settings *models.ProviderSettings, credentials *models.UserCredentials) (models.RepositoryProvider, error) {
+	return GitRemote(settings.BaseUrl, settings.ProbeRepository, settings.SshKnownHosts, credentials, settings.RequestTimeout), nil
+}
+
+// validateKnownHosts makes sure every entry is a valid known_hosts line.
+func validateKnownHosts(knownHosts string) error {
+	rest := []byte(knownHosts)
+	for len(bytes.TrimSpace(rest)) > 0 {
+		var err error
+		_, _, _, _, rest, err = ssh.ParseKnownHosts(rest)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
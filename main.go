@@ -1,9 +1,21 @@
 package main
 
 import (
-	"github.com/dogboy21/poddy/poddy"
+	"fmt"
+	"os"
+
+	"github.com/dogboy21/poddy/cli"
+
+	_ "github.com/dogboy21/poddy/bitbucketserver"
+	_ "github.com/dogboy21/poddy/gitea"
+	_ "github.com/dogboy21/poddy/github"
+	_ "github.com/dogboy21/poddy/gitlab"
+	_ "github.com/dogboy21/poddy/gitremote"
 )
 
 func main() {
-	poddy.Start()
+	if err := cli.Run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrForbidden           = errors.New("forbidden")
+	ErrRateLimited         = errors.New("rate limited")
+	ErrUpstreamUnavailable = errors.New("upstream unavailable")
+	ErrUnsupported         = errors.New("unsupported")
+)
+
+// ProviderError is returned by repository providers for failed upstream
+// requests. It unwraps to one of the Err* values above so callers can
+// check it with errors.Is.
+type ProviderError struct {
+	Kind       error
+	StatusCode int
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+	}
+
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("%v (status code %d)", e.Kind, e.StatusCode)
+	}
+
+	return e.Kind.Error()
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Kind
+}
+
+func NewResponseError(resp *http.Response) *ProviderError {
+	providerError := &ProviderError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: ParseRetryAfter(resp.Header, time.Now()),
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		providerError.Kind = ErrNotFound
+	case resp.StatusCode == http.StatusUnauthorized:
+		providerError.Kind = ErrUnauthorized
+	case resp.StatusCode == http.StatusTooManyRequests:
+		providerError.Kind = ErrRateLimited
+	case resp.StatusCode == http.StatusForbidden:
+		// GitHub reports exhausted rate limits as 403
+		if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+			providerError.Kind = ErrRateLimited
+		} else {
+			providerError.Kind = ErrForbidden
+		}
+	case resp.StatusCode >= 500:
+		providerError.Kind = ErrUpstreamUnavailable
+	default:
+		providerError.Kind = fmt.Errorf("invalid status code: %d", resp.StatusCode)
+	}
+
+	return providerError
+}
+
+func NewTransportError(err error) *ProviderError {
+	// the token source may already have classified the error
+	var providerError *ProviderError
+	if errors.As(err, &providerError) {
+		return providerError
+	}
+
+	// failing to refresh the oauth token means the user has to log in again
+	var retrieveError *oauth2.RetrieveError
+	if errors.As(err, &retrieveError) {
+		return &ProviderError{
+			Kind: ErrUnauthorized,
+			Err:  err,
+		}
+	}
+
+	// the caller went away, there is no point in retrying the request
+	if errors.Is(err, context.Canceled) {
+		return &ProviderError{
+			Kind: context.Canceled,
+			Err:  err,
+		}
+	}
+
+	return &ProviderError{
+		Kind: ErrUpstreamUnavailable,
+		Err:  err,
+	}
+}
+
+func HasStatusCode(err error, statusCode int) bool {
+	var providerError *ProviderError
+	return errors.As(err, &providerError) && providerError.StatusCode == statusCode
+}
+
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrUpstreamUnavailable)
+}
+
+// ParseRetryAfter reads how long to wait before the next request from the
+// Retry-After header or, failing that, from the unix timestamp in the
+// RateLimit-Reset / X-RateLimit-Reset headers.
+func ParseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if retryAfter := header.Get("Retry-After"); len(retryAfter) > 0 {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+
+		if date, err := http.ParseTime(retryAfter); err == nil && date.After(now) {
+			return date.Sub(now)
+		}
+	}
+
+	for _, key := range []string{"RateLimit-Reset", "X-RateLimit-Reset"} {
+		if reset, err := strconv.ParseInt(header.Get(key), 10, 64); err == nil {
+			resetTime := time.Unix(reset, 0)
+			if resetTime.After(now) {
+				return resetTime.Sub(now)
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "no headers", header: http.Header{}, want: 0},
+		{name: "retry-after seconds", header: http.Header{"Retry-After": {"120"}}, want: 2 * time.Minute},
+		{name: "retry-after date", header: http.Header{"Retry-After": {now.Add(90 * time.Second).Format(http.TimeFormat)}}, want: 90 * time.Second},
+		{name: "retry-after date in the past", header: http.Header{"Retry-After": {now.Add(-time.Minute).Format(http.TimeFormat)}}, want: 0},
+		{name: "invalid retry-after", header: http.Header{"Retry-After": {"soon"}}, want: 0},
+		{name: "ratelimit-reset", header: http.Header{"Ratelimit-Reset": {strconv.FormatInt(now.Add(42*time.Second).Unix(), 10)}}, want: 42 * time.Second},
+		{name: "x-ratelimit-reset", header: http.Header{"X-Ratelimit-Reset": {strconv.FormatInt(now.Add(time.Hour).Unix(), 10)}}, want: time.Hour},
+		{name: "reset in the past", header: http.Header{"Ratelimit-Reset": {strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)}}, want: 0},
+		{
+			name: "retry-after takes precedence",
+			header: http.Header{
+				"Retry-After":     {"5"},
+				"Ratelimit-Reset": {strconv.FormatInt(now.Add(time.Hour).Unix(), 10)},
+			},
+			want: 5 * time.Second,
+		},
+		{
+			name: "invalid retry-after falls back to reset",
+			header: http.Header{
+				"Retry-After":     {"soon"},
+				"Ratelimit-Reset": {strconv.FormatInt(now.Add(time.Minute).Unix(), 10)},
+			},
+			want: time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseRetryAfter(tt.header, now); got != tt.want {
+				t.Errorf("ParseRetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewResponseError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		header     http.Header
+		wantKind   error
+		retryable  bool
+	}{
+		{name: "not found", statusCode: http.StatusNotFound, wantKind: ErrNotFound},
+		{name: "unauthorized", statusCode: http.StatusUnauthorized, wantKind: ErrUnauthorized},
+		{name: "forbidden", statusCode: http.StatusForbidden, wantKind: ErrForbidden},
+		{name: "github rate limit", statusCode: http.StatusForbidden, header: http.Header{"X-Ratelimit-Remaining": {"0"}}, wantKind: ErrRateLimited, retryable: true},
+		{name: "too many requests", statusCode: http.StatusTooManyRequests, wantKind: ErrRateLimited, retryable: true},
+		{name: "service unavailable", statusCode: http.StatusServiceUnavailable, wantKind: ErrUpstreamUnavailable, retryable: true},
+		{name: "bad gateway", statusCode: http.StatusBadGateway, wantKind: ErrUpstreamUnavailable, retryable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+
+			err := NewResponseError(&http.Response{StatusCode: tt.statusCode, Header: header})
+			if !errors.Is(err, tt.wantKind) {
+				t.Errorf("NewResponseError() = %v, want %v", err, tt.wantKind)
+			}
+
+			if err.StatusCode != tt.statusCode {
+				t.Errorf("StatusCode = %d, want %d", err.StatusCode, tt.statusCode)
+			}
+
+			if IsRetryable(err) != tt.retryable {
+				t.Errorf("IsRetryable() = %v, want %v", IsRetryable(err), tt.retryable)
+			}
+		})
+	}
+
+	t.Run("unexpected status", func(t *testing.T) {
+		err := NewResponseError(&http.Response{StatusCode: http.StatusTeapot, Header: http.Header{}})
+		if IsRetryable(err) || errors.Is(err, ErrNotFound) || !HasStatusCode(err, http.StatusTeapot) {
+			t.Errorf("NewResponseError() = %v", err)
+		}
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		err := NewResponseError(&http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"30"}}})
+		if err.RetryAfter != 30*time.Second {
+			t.Errorf("RetryAfter = %v, want %v", err.RetryAfter, 30*time.Second)
+		}
+	})
+}
@@ -1,13 +1,46 @@
 package models
 
+import (
+	"context"
+	"errors"
+)
+
 type RepositoryProvider interface {
-	GetSelfUser() (User, error)
-	GetProject(slug string) (Project, error)
-	DoesProjectBranchExist(slug, branchName string) (bool, error)
-	GetProjectFile(slug, ref, path string) ([]byte, error)
+	GetSelfUser(ctx context.Context) (User, error)
+	GetProject(ctx context.Context, slug string) (Project, error)
+	DoesProjectBranchExist(ctx context.Context, slug, branchName string) (bool, error)
+	GetProjectFile(ctx context.Context, slug, ref, path string) ([]byte, error)
+
+	// ListProjects and ListBranches return one page of results starting at
+	// page 1 along with the number of the next page, which is 0 on the last page.
+	ListProjects(ctx context.Context, search string, page int) ([]Project, int, error)
+	ListBranches(ctx context.Context, slug, search string, page int) ([]Branch, int, error)
+
+	// GetMergeRequest returns nil if the merge request doesn't exist.
+	GetMergeRequest(ctx context.Context, slug string, number int) (MergeRequest, error)
+
+	// ResolveRef looks up a branch, tag or commit sha, in that order, and
+	// returns nil if none of them match.
+	ResolveRef(ctx context.Context, slug, ref string) (*ResolvedRef, error)
 }
 
+// TokenScopeChecker is implemented by providers that can look up which scopes
+// were granted to their access token, which is used to validate personal
+// access tokens before accepting them.
+type TokenScopeChecker interface {
+	// MissingTokenScopes returns the required scopes the token lacks, or
+	// ErrScopesUnverified if the granted scopes can't be determined.
+	MissingTokenScopes(ctx context.Context, required []string) ([]string, error)
+}
+
+// ErrScopesUnverified is returned for tokens the provider doesn't report the
+// scopes of, like GitHub's fine-grained tokens.
+var ErrScopesUnverified = errors.New("token scopes can't be verified")
+
 type User interface {
+	// GetId returns an identifier that, unlike the username, doesn't change
+	// when the user is renamed.
+	GetId() string
 	GetUsername() string
 	GetDisplayName() string
 	GetEmail() string
@@ -20,3 +53,94 @@ type Project interface {
 	GetHttpCloneUrl() string
 	GetDefaultBranch() string
 }
+
+type Branch interface {
+	GetName() string
+}
+
+type MergeRequest interface {
+	GetNumber() int
+	GetSourceBranch() string
+	GetTargetBranch() string
+	GetHeadSha() string
+	// GetHeadRef returns the ref in the target project that points to the
+	// merge request head, which is the only way to reach commits from forks.
+	GetHeadRef() string
+	IsFromFork() bool
+}
+
+const ListPageSize = 20
+
+type RefType string
+
+const (
+	RefTypeBranch RefType = "branch"
+	RefTypeTag    RefType = "tag"
+	RefTypeCommit RefType = "commit"
+)
+
+type ResolvedRef struct {
+	Name   string
+	Type   RefType
+	Commit string
+}
+
+func LooksLikeCommitSha(ref string) bool {
+	if len(ref) < 7 || len(ref) > 40 {
+		return false
+	}
+
+	for _, c := range ref {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') && !(c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+
+	return true
+}
+
+type GitCredentials struct {
+	Username      string
+	Password      string
+	SshPrivateKey string
+	// the known_hosts entries of the remote, without them any host key is
+	// accepted on first use
+	SshKnownHosts string
+}
+
+type UserCredentials struct {
+	Username      string `json:"username"`
+	Password      string `json:"password,omitempty"`
+	SshPrivateKey string `json:"ssh_private_key,omitempty"`
+	DisplayName   string `json:"display_name"`
+	Email         string `json:"email"`
+}
+
+func (c *UserCredentials) GetGitCredentials() *GitCredentials {
+	return &GitCredentials{
+		Username:      c.Username,
+		Password:      c.Password,
+		SshPrivateKey: c.SshPrivateKey,
+	}
+}
+
+// MissingScopes returns the required scopes that are neither granted nor
+// implied by one of the granted scopes.
+func MissingScopes(granted, required []string, implied map[string][]string) []string {
+	grantedSet := make(map[string]bool)
+	for _, scope := range granted {
+		grantedSet[scope] = true
+		for _, impliedScope := range implied[scope] {
+			grantedSet[impliedScope] = true
+		}
+	}
+
+	missing := make([]string, 0)
+	for _, scope := range required {
+		if !grantedSet[scope] {
+			missing = append(missing, scope)
+		}
+	}
+
+	return missing
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMissingScopes(t *testing.T) {
+	implied := map[string][]string{
+		"repo": {"repo:status", "public_repo"},
+		"api":  {"read_api", "read_user"},
+	}
+
+	tests := []struct {
+		name     string
+		granted  []string
+		required []string
+		want     []string
+	}{
+		{name: "all granted", granted: []string{"read_user", "api"}, required: []string{"read_user", "api"}, want: []string{}},
+		{name: "implied", granted: []string{"api"}, required: []string{"read_api", "read_user"}, want: []string{}},
+		{name: "missing", granted: []string{"read_user"}, required: []string{"read_user", "api"}, want: []string{"api"}},
+		{name: "implication isn't reversed", granted: []string{"public_repo"}, required: []string{"repo"}, want: []string{"repo"}},
+		{name: "nothing granted", granted: nil, required: []string{"repo", "read:org"}, want: []string{"repo", "read:org"}},
+		{name: "nothing required", granted: []string{"repo"}, required: nil, want: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MissingScopes(tt.granted, tt.required, implied); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MissingScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
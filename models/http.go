@@ -0,0 +1,138 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
+
+// RoundTripWithTimeout sends the request with the timeout applied on top of
+// its context. The timeout keeps running until the response body is closed,
+// so reading a stalled body is bounded as well.
+func RoundTripWithTimeout(transport http.RoundTripper, req *http.Request, timeout time.Duration) (*http.Response, error) {
+	if timeout <= 0 {
+		return transport.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+
+	resp, err := transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelOnCloseBody{
+		ReadCloser: resp.Body,
+		cancel:     cancel,
+	}
+
+	return resp, nil
+}
+
+// ApiClient sends the GET requests of a repository provider's REST API. All
+// providers share it, so they apply the request timeout and classify failed
+// requests and unsuccessful responses into ProviderErrors the same way.
+type ApiClient struct {
+	BaseUrl   *url.URL
+	Transport http.RoundTripper
+	Timeout   time.Duration
+}
+
+func NewApiClient(baseUrl *url.URL, source oauth2.TokenSource, timeout time.Duration) *ApiClient {
+	return &ApiClient{
+		BaseUrl: baseUrl,
+		Transport: &oauth2.Transport{
+			Source: source,
+			Base:   http.DefaultTransport,
+		},
+		Timeout: timeout,
+	}
+}
+
+// Url returns the url of the API path below the base url. Escaped segments of
+// the path, like slugs containing slashes, are kept escaped.
+func (a *ApiClient) Url(path string, queryParams url.Values) string {
+	basePath := strings.TrimSuffix(a.BaseUrl.Path, "/")
+
+	pathUnescaped, err := url.PathUnescape(path)
+	if err != nil {
+		log.Fatalf("failed to unescape path: %v\n", err)
+	}
+
+	query := ""
+	if queryParams != nil {
+		query = queryParams.Encode()
+	}
+
+	return a.BaseUrl.ResolveReference(&url.URL{
+		Path:     basePath + pathUnescaped,
+		RawPath:  basePath + path,
+		RawQuery: query,
+	}).String()
+}
+
+// Get requests the API path with the additional headers. Anything but a 200
+// response is returned as a ProviderError.
+func (a *ApiClient) Get(ctx context.Context, path string, queryParams url.Values, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", a.Url(path, queryParams), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := RoundTripWithTimeout(a.Transport, req, a.Timeout)
+	if err != nil {
+		return nil, NewTransportError(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, NewResponseError(resp)
+	}
+
+	return resp, nil
+}
+
+// EscapeSlug escapes the owner and the name of an owner/name slug separately,
+// which keeps the slash between them.
+func EscapeSlug(slug string) string {
+	parts := strings.SplitN(slug, "/", 2)
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// EscapeFilePath escapes every segment of a path within a repository.
+func EscapeFilePath(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// ProviderSettings holds the parts of a provider config block that the
+// provider factory gets to validate and complete with its own defaults.
+type ProviderSettings struct {
+	BaseUrl       *url.URL
+	ApiUrl        string
+	AuthEndpoint  string
+	TokenEndpoint string
+	Scopes        []string
+
+	// a repository only readable when authenticated, which credentials are
+	// checked against for providers that have no user API
+	ProbeRepository string
+
+	// the known_hosts entries the host keys of ssh remotes are verified
+	// against
+	SshKnownHosts string
+
+	RequestTimeout time.Duration
+}
+
+type RepositoryProviderFactory interface {
+	ValidateSettings(settings *ProviderSettings) error
+}
+
+type OauthRepositoryProviderFactory interface {
+	RepositoryProviderFactory
+	NewRepositoryProvider(settings *ProviderSettings, source oauth2.TokenSource) (RepositoryProvider, error)
+}
+
+type CredentialsRepositoryProviderFactory interface {
+	RepositoryProviderFactory
+	NewRepositoryProvider(settings *ProviderSettings, credentials *UserCredentials) (RepositoryProvider, error)
+}
+
+var (
+	providerFactoriesMu sync.RWMutex
+	providerFactories   = make(map[string]RepositoryProviderFactory)
+)
+
+func RegisterRepositoryProvider(typeName string, factory RepositoryProviderFactory) {
+	providerFactoriesMu.Lock()
+	defer providerFactoriesMu.Unlock()
+
+	if factory == nil {
+		panic("models: repository provider factory is nil")
+	}
+
+	switch factory.(type) {
+	case OauthRepositoryProviderFactory, CredentialsRepositoryProviderFactory:
+	default:
+		panic(fmt.Sprintf("models: repository provider factory for %s has no constructor", typeName))
+	}
+
+	if _, exists := providerFactories[typeName]; exists {
+		panic(fmt.Sprintf("models: repository provider %s registered twice", typeName))
+	}
+
+	providerFactories[typeName] = factory
+}
+
+func GetRepositoryProviderFactory(typeName string) RepositoryProviderFactory {
+	providerFactoriesMu.RLock()
+	defer providerFactoriesMu.RUnlock()
+
+	return providerFactories[typeName]
+}
+
+func RepositoryProviderTypes() []string {
+	providerFactoriesMu.RLock()
+	defer providerFactoriesMu.RUnlock()
+
+	types := make([]string, 0, len(providerFactories))
+	for typeName := range providerFactories {
+		types = append(types, typeName)
+	}
+
+	sort.Strings(types)
+
+	return types
+}
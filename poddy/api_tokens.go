@@ -0,0 +1,275 @@
+package poddy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dogboy21/poddy/sessionstore"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	ScopeWorkspacesRead  = "workspaces:read"
+	ScopeWorkspacesWrite = "workspaces:write"
+	ScopeProjectsRead    = "projects:read"
+
+	apiTokenScopesKey = "poddy_api_token_scopes"
+
+	defaultApiTokenLifetimeDays = 90
+	maxApiTokenLifetimeDays     = 365
+)
+
+var apiTokenScopes = []string{ScopeWorkspacesRead, ScopeWorkspacesWrite, ScopeProjectsRead}
+
+// bearerAuth replaces the cookie session with the session of the api token if
+// the request carries one.
+func (p *poddy) bearerAuth(c *gin.Context) {
+	authorization := c.GetHeader("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return
+	}
+
+	if p.sessionStore == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, map[string]interface{}{
+			"error": "api tokens require a server-side session store",
+		})
+		return
+	}
+
+	tokenSession, err := p.sessionStore.LoadApiToken(c.Request.Context(), strings.TrimPrefix(authorization, "Bearer "))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if tokenSession == nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, map[string]interface{}{
+			"error": "invalid api token",
+		})
+		return
+	}
+
+	c.Set(sessions.DefaultKey, tokenSession)
+	c.Set(apiTokenScopesKey, tokenSession.Scopes())
+}
+
+// requireScope rejects api tokens that weren't granted the scope. Cookie
+// sessions may do everything.
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, ok := c.Get(apiTokenScopesKey)
+		if !ok {
+			return
+		}
+
+		for _, grantedScope := range scopes.([]string) {
+			if grantedScope == scope {
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, map[string]interface{}{
+			"error":          "api token is missing the required scope",
+			"required_scope": scope,
+		})
+	}
+}
+
+// requireCookieSession keeps api tokens from managing sessions and minting
+// further tokens.
+func requireCookieSession(c *gin.Context) {
+	if _, ok := c.Get(apiTokenScopesKey); ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, map[string]interface{}{
+			"error": "not available for api tokens",
+		})
+	}
+}
+
+// apiTokenValues collects the identity and linked provider credentials of the
+// session that are handed on to an api token. Provider tokens are shared with
+// the api token instead of copied, as a refresh through either of them would
+// leave the other with a dead refresh token.
+func (p *poddy) apiTokenValues(ctx context.Context, session sessions.Session, expiresAt time.Time) (map[interface{}]interface{}, error) {
+	values := make(map[interface{}]interface{})
+
+	copyValue := func(key string) {
+		if value := session.Get(key); value != nil {
+			values[key] = value
+		}
+	}
+
+	copyValue(sessionPoddyUserKey)
+
+	for _, providerConfig := range p.oauthRepositoryProviderConfigs {
+		ref, err := shareSessionToken(ctx, session, &providerConfig, expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to share token of %s: %w", providerConfig.ID, err)
+		}
+
+		if len(ref) > 0 {
+			values[sessionTokenRefKey(&providerConfig)] = ref
+		}
+
+		copyValue(credentialsSessionKey(&providerConfig))
+		copyValue(sessionUserKey(&providerConfig))
+		copyValue(sessionUserIdKey(&providerConfig))
+	}
+
+	if err := session.Save(); err != nil {
+		return nil, fmt.Errorf("failed to save session: %w", err)
+	}
+
+	return values, nil
+}
+
+// createApiToken mints an api token for the current session and aborts the
+// request if that isn't possible.
+func (p *poddy) createApiToken(c *gin.Context, name string, scopes []string, expiresInDays int) (string, time.Time, bool) {
+	expiresAt := time.Now().AddDate(0, 0, expiresInDays)
+
+	values, err := p.apiTokenValues(c.Request.Context(), sessions.Default(c), expiresAt)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return "", time.Time{}, false
+	}
+
+	if len(values) == 0 {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("not logged in"))
+		return "", time.Time{}, false
+	}
+
+	token, err := p.sessionStore.CreateApiToken(c.Request.Context(), name, scopes, values, expiresAt)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create api token: %w", err))
+		return "", time.Time{}, false
+	}
+
+	return token, expiresAt, true
+}
+
+type createApiTokenBody struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+func (p *poddy) createApiTokenHandler(c *gin.Context) {
+	if p.sessionStore == nil {
+		c.AbortWithStatus(http.StatusNotImplemented)
+		return
+	}
+
+	var body createApiTokenBody
+
+	if err := c.ShouldBind(&body); err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to bind request body: %v", err))
+		return
+	}
+
+	for _, scope := range body.Scopes {
+		valid := false
+		for _, apiTokenScope := range apiTokenScopes {
+			valid = valid || scope == apiTokenScope
+		}
+
+		if !valid {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("unknown scope %q (supported scopes: %s)", scope, strings.Join(apiTokenScopes, ", ")))
+			return
+		}
+	}
+
+	if body.ExpiresInDays == 0 {
+		body.ExpiresInDays = defaultApiTokenLifetimeDays
+	}
+
+	if body.ExpiresInDays < 0 || body.ExpiresInDays > maxApiTokenLifetimeDays {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("expires_in_days must be between 1 and %d", maxApiTokenLifetimeDays))
+		return
+	}
+
+	token, expiresAt, ok := p.createApiToken(c, body.Name, body.Scopes, body.ExpiresInDays)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusCreated, map[string]interface{}{
+		"token":      token,
+		"name":       body.Name,
+		"scopes":     body.Scopes,
+		"expires_at": expiresAt,
+	})
+}
+
+func (p *poddy) listApiTokensHandler(c *gin.Context) {
+	if p.sessionStore == nil {
+		c.AbortWithStatus(http.StatusNotImplemented)
+		return
+	}
+
+	apiTokens, _, err := p.userSessions(c, sessionstore.KindApiToken)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to list api tokens: %w", err))
+		return
+	}
+
+	if apiTokens == nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	items := make([]map[string]interface{}, len(apiTokens))
+	for i, apiToken := range apiTokens {
+		var lastUsed interface{}
+		if !apiToken.UpdatedAt.IsZero() {
+			lastUsed = apiToken.UpdatedAt
+		}
+
+		items[i] = map[string]interface{}{
+			"id":         apiToken.Handle,
+			"name":       apiToken.Label,
+			"scopes":     apiToken.Scopes,
+			"created_at": apiToken.CreatedAt,
+			"last_used":  lastUsed,
+			"expires_at": apiToken.ExpiresAt,
+		}
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+func (p *poddy) revokeApiTokenHandler(c *gin.Context) {
+	if p.sessionStore == nil {
+		c.AbortWithStatus(http.StatusNotImplemented)
+		return
+	}
+
+	apiTokens, _, err := p.userSessions(c, sessionstore.KindApiToken)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to list api tokens: %w", err))
+		return
+	}
+
+	if apiTokens == nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	for _, apiToken := range apiTokens {
+		if apiToken.Handle == c.Param("id") {
+			if err := p.sessionStore.RevokeSession(c.Request.Context(), apiToken); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to revoke api token: %w", err))
+				return
+			}
+
+			c.Status(http.StatusNoContent)
+			return
+		}
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
@@ -0,0 +1,170 @@
+package poddy
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	cliLoginCsrfKey = "poddy_cli_login_csrf"
+
+	// the command line client only manages workspaces
+	cliApiTokenLifetimeDays = 30
+)
+
+var cliApiTokenScopes = []string{ScopeWorkspacesRead, ScopeWorkspacesWrite}
+
+var cliConsentTemplate = template.Must(template.New("cli-consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorize the poddy CLI</title>
+</head>
+<body>
+<h1>Authorize the poddy CLI</h1>
+<p>{{.Name}} asks for an api token that expires in {{.LifetimeDays}} days and is allowed to:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+<p>Only authorize it if you just ran <code>poddy login</code> yourself.</p>
+<form method="post" action="/auth/cli">
+<input type="hidden" name="csrf_token" value="{{.CsrfToken}}">
+<input type="hidden" name="port" value="{{.Port}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="name" value="{{.ClientName}}">
+<button type="submit">Authorize</button>
+<a href="/">Cancel</a>
+</form>
+</body>
+</html>
+`))
+
+type cliLoginRequest struct {
+	Port       int
+	State      string
+	ClientName string
+}
+
+func parseCliLoginRequest(port, state, clientName string) (*cliLoginRequest, error) {
+	request := &cliLoginRequest{
+		State:      state,
+		ClientName: clientName,
+	}
+
+	var err error
+	request.Port, err = strconv.Atoi(port)
+	if err != nil || request.Port <= 0 || request.Port > 65535 {
+		return nil, errors.New("invalid callback port")
+	}
+
+	if len(state) == 0 {
+		return nil, errors.New("missing state")
+	}
+
+	return request, nil
+}
+
+func (r *cliLoginRequest) tokenName() string {
+	if len(r.ClientName) > 0 {
+		return fmt.Sprintf("poddy CLI on %s", r.ClientName)
+	}
+
+	return "poddy CLI"
+}
+
+// cliLoginHandler asks the user to confirm that the command line client may
+// get an api token, so visiting a crafted login link can't mint one.
+func (p *poddy) cliLoginHandler(c *gin.Context) {
+	if p.sessionStore == nil {
+		c.AbortWithStatus(http.StatusNotImplemented)
+		return
+	}
+
+	request, err := parseCliLoginRequest(c.Query("port"), c.Query("state"), c.Query("name"))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	csrfToken := hex.EncodeToString(securecookie.GenerateRandomKey(32))
+
+	session := sessions.Default(c)
+	session.Set(cliLoginCsrfKey, csrfToken)
+	if err := session.Save(); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to save session: %w", err))
+		return
+	}
+
+	// the consent page must not be framed to trick users into clicking it
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+	c.Header("Cache-Control", "no-store")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	if err := cliConsentTemplate.Execute(c.Writer, map[string]interface{}{
+		"Name":         request.tokenName(),
+		"LifetimeDays": cliApiTokenLifetimeDays,
+		"Scopes":       cliApiTokenScopes,
+		"CsrfToken":    csrfToken,
+		"Port":         request.Port,
+		"State":        request.State,
+		"ClientName":   request.ClientName,
+	}); err != nil {
+		c.Error(fmt.Errorf("failed to render cli consent page: %w", err))
+	}
+}
+
+// cliLoginConsentHandler mints the api token once the user has confirmed the
+// login and hands it to the client's callback listener, which has to be on
+// the loopback interface.
+func (p *poddy) cliLoginConsentHandler(c *gin.Context) {
+	if p.sessionStore == nil {
+		c.AbortWithStatus(http.StatusNotImplemented)
+		return
+	}
+
+	session := sessions.Default(c)
+	expectedCsrfToken, _ := session.Get(cliLoginCsrfKey).(string)
+	session.Delete(cliLoginCsrfKey)
+	session.Save()
+
+	csrfToken := c.PostForm("csrf_token")
+	if len(expectedCsrfToken) == 0 || subtle.ConstantTimeCompare([]byte(csrfToken), []byte(expectedCsrfToken)) != 1 {
+		c.AbortWithError(http.StatusForbidden, errors.New("invalid csrf token"))
+		return
+	}
+
+	request, err := parseCliLoginRequest(c.PostForm("port"), c.PostForm("state"), c.PostForm("name"))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	token, _, ok := p.createApiToken(c, request.tokenName(), cliApiTokenScopes, cliApiTokenLifetimeDays)
+	if !ok {
+		return
+	}
+
+	callbackUrl := url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("127.0.0.1:%d", request.Port),
+		Path:   "/callback",
+		RawQuery: url.Values{
+			"state": {request.State},
+			"token": {token},
+		}.Encode(),
+	}
+
+	c.Redirect(http.StatusSeeOther, callbackUrl.String())
+}
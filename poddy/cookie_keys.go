@@ -0,0 +1,103 @@
+package poddy
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/dogboy21/poddy/config"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const cookieKeysSecretName = "poddy-cookie-keys"
+
+// cookieKeyPairs returns the configured cookie key pairs. Instead of the
+// default secret, release builds use a generated key pair that is persisted in
+// a Kubernetes Secret, so it survives restarts and is shared between replicas.
+func cookieKeyPairs() ([][]byte, error) {
+	if !config.ServerCookieSecretIsDefault() {
+		return config.ServerCookieKeyPairs()
+	}
+
+	if gin.Mode() != gin.ReleaseMode {
+		log.Println("warning: using the default cookie secret, configure server.cookieSecret before exposing poddy")
+		return config.ServerCookieKeyPairs()
+	}
+
+	keyPairs, err := loadOrCreateCookieKeys(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("refusing to start with the default cookie secret in release mode, set server.cookieSecret (generating a secret failed: %w)", err)
+	}
+
+	return keyPairs, nil
+}
+
+func decodeCookieKeysSecret(secret *corev1.Secret) ([][]byte, error) {
+	authenticationKey, err := hex.DecodeString(string(secret.Data["authentication"]))
+	if err != nil || len(authenticationKey) == 0 {
+		return nil, fmt.Errorf("invalid authentication key in secret %s", secret.Name)
+	}
+
+	encryptionKey, err := hex.DecodeString(string(secret.Data["encryption"]))
+	if err != nil || len(encryptionKey) != 32 {
+		return nil, fmt.Errorf("invalid encryption key in secret %s", secret.Name)
+	}
+
+	return [][]byte{authenticationKey, encryptionKey}, nil
+}
+
+func loadOrCreateCookieKeys(ctx context.Context) ([][]byte, error) {
+	kubernetesConfig, err := getKubernetesConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes config: %w", err)
+	}
+
+	clientSet, err := kubernetes.NewForConfig(kubernetesConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	secrets := clientSet.CoreV1().Secrets(config.DeploymentNamespace())
+
+	secret, err := secrets.Get(ctx, cookieKeysSecretName, metav1.GetOptions{})
+	if err == nil {
+		return decodeCookieKeysSecret(secret)
+	}
+
+	if !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get secret %s: %w", cookieKeysSecretName, err)
+	}
+
+	secret, err = secrets.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cookieKeysSecretName,
+			Namespace: config.DeploymentNamespace(),
+			Labels: map[string]string{
+				"managed-by": "poddy",
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"authentication": []byte(hex.EncodeToString(securecookie.GenerateRandomKey(64))),
+			"encryption":     []byte(hex.EncodeToString(securecookie.GenerateRandomKey(32))),
+		},
+	}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		// another replica was faster
+		secret, err = secrets.Get(ctx, cookieKeysSecretName, metav1.GetOptions{})
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secret %s: %w", cookieKeysSecretName, err)
+	}
+
+	log.Printf("generated cookie keys and stored them in secret %s\n", cookieKeysSecretName)
+
+	return decodeCookieKeysSecret(secret)
+}
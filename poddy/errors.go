@@ -0,0 +1,73 @@
+package poddy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/dogboy21/poddy/config"
+	"github.com/dogboy21/poddy/models"
+	"github.com/gin-gonic/gin"
+)
+
+func providerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, models.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, models.ErrUnauthorized):
+		return http.StatusUnauthorized
+	case errors.Is(err, models.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, models.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, models.ErrUpstreamUnavailable):
+		return http.StatusBadGateway
+	case errors.Is(err, models.ErrUnsupported):
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// abortWithProviderError aborts the request with the status code matching
+// the provider error and passes on how long a rate limited client should wait.
+func abortWithProviderError(c *gin.Context, providerConfig *config.OauthRepositoryProviderConfig, err error, message string) {
+	if errors.Is(err, models.ErrUnauthorized) {
+		abortWithLoginRequired(c, providerConfig, fmt.Errorf("%s: %w", message, err))
+		return
+	}
+
+	var providerError *models.ProviderError
+	if errors.As(err, &providerError) && providerError.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(providerError.RetryAfter.Seconds()))))
+	}
+
+	c.AbortWithError(providerErrorStatus(err), fmt.Errorf("%s: %w", message, err))
+}
+
+// abortWithLoginRequired aborts the request with a 401 telling the client
+// where the user has to log in again to the provider.
+func abortWithLoginRequired(c *gin.Context, providerConfig *config.OauthRepositoryProviderConfig, err error) {
+	if err != nil {
+		c.Error(err)
+	}
+
+	loginType := providerConfig.LoginModes[0]
+
+	loginUrl := fmt.Sprintf("/oauth/auth/%s", providerConfig.ID)
+	switch loginType {
+	case config.LoginModeToken:
+		loginUrl = fmt.Sprintf("/oauth/token/%s", providerConfig.ID)
+	case config.LoginModeCredentials:
+		loginUrl = fmt.Sprintf("/oauth/credentials/%s", providerConfig.ID)
+	}
+
+	c.AbortWithStatusJSON(http.StatusUnauthorized, map[string]interface{}{
+		"error":     "login required",
+		"provider":  providerConfig.ID,
+		"login":     loginType,
+		"login_url": loginUrl,
+	})
+}
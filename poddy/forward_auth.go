@@ -0,0 +1,376 @@
+package poddy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dogboy21/poddy/config"
+	"github.com/dogboy21/poddy/models"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// the workspace authentication runs for every request to a workspace, so the
+// workspace services are cached for a short while instead of asking
+// Kubernetes each time
+const workspaceEndpointCacheTtl = 30 * time.Second
+
+// workspaceEndpoint holds the owner labels and the ports of a workspace's
+// service.
+type workspaceEndpoint struct {
+	Name   string
+	Labels map[string]string
+	Ports  map[string]int32
+}
+
+type cachedWorkspaceEndpoint struct {
+	endpoint  *workspaceEndpoint
+	expiresAt time.Time
+}
+
+type workspaceEndpointCache struct {
+	mutex   sync.Mutex
+	entries map[string]cachedWorkspaceEndpoint
+}
+
+// get returns the endpoint of the workspace or nil if there is no such
+// workspace.
+func (w *workspaceEndpointCache) get(ctx context.Context, workspaceName string) (*workspaceEndpoint, error) {
+	w.mutex.Lock()
+	entry, ok := w.entries[workspaceName]
+	w.mutex.Unlock()
+
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.endpoint, nil
+	}
+
+	kubernetesConfig, err := getKubernetesConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes config: %w", err)
+	}
+
+	clientSet, err := kubernetes.NewForConfig(kubernetesConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	var endpoint *workspaceEndpoint
+
+	service, err := clientSet.CoreV1().Services(config.DeploymentNamespace()).Get(ctx, workspaceName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get service: %w", err)
+	}
+
+	if err == nil && service.Labels["managed-by"] == "poddy" {
+		endpoint = &workspaceEndpoint{
+			Name:   workspaceName,
+			Labels: service.Labels,
+			Ports:  make(map[string]int32),
+		}
+
+		for _, port := range service.Spec.Ports {
+			endpoint.Ports[port.Name] = port.Port
+		}
+	}
+
+	w.mutex.Lock()
+	if w.entries == nil {
+		w.entries = make(map[string]cachedWorkspaceEndpoint)
+	}
+	w.entries[workspaceName] = cachedWorkspaceEndpoint{
+		endpoint:  endpoint,
+		expiresAt: time.Now().Add(workspaceEndpointCacheTtl),
+	}
+	w.mutex.Unlock()
+
+	return endpoint, nil
+}
+
+// ingressAuthAnnotations returns the annotations that make the ingress
+// controller authenticate every request to a workspace against poddy.
+func ingressAuthAnnotations() map[string]string {
+	switch config.DeploymentAuth() {
+	case config.DeploymentAuthNginx:
+		return map[string]string{
+			"nginx.ingress.kubernetes.io/auth-url":              config.ServerUrl().ResolveReference(&url.URL{Path: "/auth/workspace"}).String(),
+			"nginx.ingress.kubernetes.io/auth-signin":           config.ServerUrl().ResolveReference(&url.URL{Path: "/auth/workspace/signin"}).String(),
+			"nginx.ingress.kubernetes.io/auth-response-headers": "X-Poddy-User",
+		}
+	case config.DeploymentAuthTraefik:
+		return map[string]string{
+			"traefik.ingress.kubernetes.io/router.middlewares": config.DeploymentTraefikMiddleware(),
+		}
+	}
+
+	return nil
+}
+
+// workspaceNameCandidates returns the names of the workspaces the host may
+// belong to. Workspaces are served from <name>.<base domain> and their
+// services from <service>-<name>.<base domain>.
+func workspaceNameCandidates(host string) []string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	host = strings.ToLower(host)
+	subdomain := strings.TrimSuffix(host, "."+config.DeploymentBaseDomain())
+	if subdomain == host || len(subdomain) == 0 || strings.Contains(subdomain, ".") {
+		return nil
+	}
+
+	candidates := []string{subdomain}
+	for i, c := range subdomain {
+		if c == '-' {
+			candidates = append(candidates, subdomain[i+1:])
+		}
+	}
+
+	return candidates
+}
+
+// forwardedUrl reconstructs the url of the request that is being
+// authenticated from the headers set by Traefik or nginx.
+func forwardedUrl(r *http.Request) *url.URL {
+	if host := r.Header.Get("X-Forwarded-Host"); len(host) > 0 && len(r.Header.Get("X-Forwarded-Uri")) > 0 {
+		scheme := r.Header.Get("X-Forwarded-Proto")
+		if len(scheme) == 0 {
+			scheme = "https"
+		}
+
+		forwarded, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, host, r.Header.Get("X-Forwarded-Uri")))
+		if err == nil {
+			return forwarded
+		}
+	}
+
+	if original, err := url.Parse(r.Header.Get("X-Original-URL")); err == nil && len(original.Host) > 0 {
+		return original
+	}
+
+	return nil
+}
+
+// sessionOwnsWorkspace checks the identities of the session against the owner
+// labels of a workspace. The provider account must still be linked to the
+// session unless the workspace belongs to the poddy user.
+func (p *poddy) sessionOwnsWorkspace(session sessions.Session, labels map[string]string) (bool, error) {
+	poddyUser, err := ReadPoddyUserFromSession(session)
+	if err != nil {
+		return false, err
+	}
+
+	if poddyUser != nil && labels["workspace-owner"] == (&workspaceOwner{PoddyUser: poddyUser}).ownerHash() {
+		return true, nil
+	}
+
+	for _, providerConfig := range p.oauthRepositoryProviderConfigs {
+		userId := ReadUserIdFromSession(session, &providerConfig)
+		if len(userId) == 0 {
+			continue
+		}
+
+		owner := &workspaceOwner{
+			ProviderID: providerConfig.ID,
+			UserID:     userId,
+		}
+
+		if owner.owns(labels) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// resolveWorkspaceHost resolves the workspace served from the host along with
+// the name of the service port and makes sure the caller owns it.
+// models.ErrForbidden is returned for hosts of other users' workspaces and
+// hosts that don't belong to any workspace.
+func (p *poddy) resolveWorkspaceHost(ctx context.Context, host string, owns func(labels map[string]string) (bool, error)) (*workspaceEndpoint, string, error) {
+	candidates := workspaceNameCandidates(host)
+
+	for _, workspaceName := range candidates {
+		endpoint, err := p.workspaceEndpoints.get(ctx, workspaceName)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to look up workspace: %w", err)
+		}
+
+		if endpoint == nil {
+			continue
+		}
+
+		owner, err := owns(endpoint.Labels)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to check workspace owner: %w", err)
+		}
+
+		if !owner {
+			break
+		}
+
+		portName := "server"
+		if subdomain := candidates[0]; subdomain != workspaceName {
+			portName = strings.TrimSuffix(subdomain, "-"+workspaceName)
+		}
+
+		return endpoint, portName, nil
+	}
+
+	return nil, "", models.ErrForbidden
+}
+
+// grantWorkspaceHost checks the session against the owner of the workspace
+// served from the host and grants the session's user access to it.
+func (p *poddy) grantWorkspaceHost(ctx context.Context, session sessions.Session, host string) (*workspaceGrant, error) {
+	endpoint, _, err := p.resolveWorkspaceHost(ctx, host, func(labels map[string]string) (bool, error) {
+		return p.sessionOwnsWorkspace(session, labels)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &workspaceGrant{
+		Host:     normalizeHost(host),
+		Owner:    endpoint.Labels["workspace-owner"],
+		Username: p.sessionUsername(session),
+	}, nil
+}
+
+// authorizeWorkspaceGrant resolves the workspace the grant was issued for and
+// makes sure it still belongs to the same owner.
+func (p *poddy) authorizeWorkspaceGrant(ctx context.Context, grant *workspaceGrant) (*workspaceEndpoint, string, error) {
+	return p.resolveWorkspaceHost(ctx, grant.Host, func(labels map[string]string) (bool, error) {
+		return labels["managed-by"] == "poddy" && len(grant.Owner) > 0 && labels["workspace-owner"] == grant.Owner, nil
+	})
+}
+
+// sessionUsername returns who the session is logged in as, if anyone.
+func (p *poddy) sessionUsername(session sessions.Session) string {
+	if poddyUser, _ := ReadPoddyUserFromSession(session); poddyUser != nil {
+		return poddyUser.Username
+	}
+
+	for _, providerConfig := range p.oauthRepositoryProviderConfigs {
+		if username := ReadUserFromSession(session, &providerConfig); len(username) > 0 {
+			return username
+		}
+	}
+
+	return ""
+}
+
+// workspaceSigninUrl returns where users without a workspace cookie are sent
+// to, which hands them back to the workspace with a ticket for the cookie.
+func workspaceSigninUrl(original *url.URL) *url.URL {
+	return config.ServerUrl().ResolveReference(&url.URL{
+		Path:     "/auth/workspace/signin",
+		RawQuery: url.Values{"rd": {original.String()}}.Encode(),
+	})
+}
+
+// forwardAuthHandler answers the authentication requests of nginx-ingress
+// (auth-url) and Traefik (forwardAuth) for workspace hosts. The session cookie
+// isn't sent to workspace hosts, requests are authenticated through the
+// workspace cookie of the host instead. Only the owner of the workspace is let
+// through.
+func (p *poddy) forwardAuthHandler(c *gin.Context) {
+	original := forwardedUrl(c.Request)
+	if original == nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	grant, cookie, cleanUrl := redeemWorkspaceTicket(original)
+	if grant == nil {
+		grant = readWorkspaceGrant(c.Request, original.Host)
+	}
+
+	if grant == nil {
+		// Traefik hands the response to the browser, nginx redirects on its
+		// own through the auth-signin annotation
+		if len(c.GetHeader("X-Forwarded-Uri")) > 0 && c.GetHeader("X-Forwarded-Method") == http.MethodGet {
+			c.Redirect(http.StatusFound, workspaceSigninUrl(original).String())
+			c.Abort()
+			return
+		}
+
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	_, _, err := p.authorizeWorkspaceGrant(c.Request.Context(), grant)
+	if errors.Is(err, models.ErrForbidden) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if cookie != nil {
+		http.SetCookie(c.Writer, cookie)
+
+		// Traefik passes the redirect on to the browser, which drops the
+		// ticket from the url. nginx can't redirect, it adds the cookie to the
+		// workspace's response, so the workspace sees the ticket of its own
+		// host once.
+		if config.DeploymentAuth() == config.DeploymentAuthTraefik {
+			c.Redirect(http.StatusFound, cleanUrl.String())
+			c.Abort()
+			return
+		}
+	}
+
+	c.Header("X-Poddy-User", grant.Username)
+	c.Status(http.StatusOK)
+}
+
+// forwardAuthSigninHandler sends users back to the workspace after logging in
+// with a short-lived ticket the workspace host exchanges for its cookie. With
+// OIDC the login happens through requireUser, otherwise the user has to log in
+// to a provider on the start page.
+func (p *poddy) forwardAuthSigninHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	if len(p.sessionUsername(session)) == 0 {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	returnTo, err := url.Parse(c.Query("rd"))
+	if err != nil || (returnTo.Scheme != "http" && returnTo.Scheme != "https") || workspaceNameCandidates(returnTo.Host) == nil {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+
+	grant, err := p.grantWorkspaceHost(c.Request.Context(), session, returnTo.Host)
+	if errors.Is(err, models.ErrForbidden) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	ticketUrl, err := workspaceTicketUrl(returnTo, grant)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to issue workspace ticket: %w", err))
+		return
+	}
+
+	c.Redirect(http.StatusFound, ticketUrl.String())
+}
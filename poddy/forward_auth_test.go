@@ -0,0 +1,34 @@
+package poddy
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestWorkspaceNameCandidates(t *testing.T) {
+	viper.Set("deployment.baseDomain", "ws.example.com")
+	t.Cleanup(func() { viper.Set("deployment.baseDomain", nil) })
+
+	tests := []struct {
+		host string
+		want []string
+	}{
+		{host: "happy-cat.ws.example.com", want: []string{"happy-cat", "cat"}},
+		{host: "web-happy-cat.ws.example.com:8080", want: []string{"web-happy-cat", "happy-cat", "cat"}},
+		{host: "Happy-Cat.WS.example.com", want: []string{"happy-cat", "cat"}},
+		{host: "ws.example.com", want: nil},
+		{host: "a.b.ws.example.com", want: nil},
+		{host: "happy-cat.example.com", want: nil},
+		{host: "happy-catws.example.com", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if got := workspaceNameCandidates(tt.host); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("workspaceNameCandidates(%q) = %v, want %v", tt.host, got, tt.want)
+			}
+		})
+	}
+}
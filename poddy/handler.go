@@ -2,21 +2,29 @@ package poddy
 
 import (
 	"context"
-	crand "crypto/rand"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 
+	"github.com/dogboy21/poddy/config"
+	"github.com/dogboy21/poddy/models"
+	"github.com/dogboy21/poddy/sessionstore"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
 )
 
 func (p *poddy) listOauthProvidersHandler(c *gin.Context) {
 	providers := make([]map[string]interface{}, len(p.oauthRepositoryProviderConfigs))
 	for i := 0; i < len(providers); i++ {
 		providers[i] = map[string]interface{}{
-			"id":   p.oauthRepositoryProviderConfigs[i].ID,
-			"host": p.oauthRepositoryProviderConfigs[i].Host,
+			"id":          p.oauthRepositoryProviderConfigs[i].ID,
+			"host":        p.oauthRepositoryProviderConfigs[i].Host,
+			"login":       p.oauthRepositoryProviderConfigs[i].LoginModes[0],
+			"login_modes": p.oauthRepositoryProviderConfigs[i].LoginModes,
+			"scopes":      p.oauthRepositoryProviderConfigs[i].RequiredScopes(),
 		}
 	}
 
@@ -25,40 +33,48 @@ func (p *poddy) listOauthProvidersHandler(c *gin.Context) {
 
 func (p *poddy) oauthAuthHandler(c *gin.Context) {
 	provider := p.getProviderForId(c.Param("id"))
-	if provider == nil {
+	if provider == nil || !provider.HasLoginMode(config.LoginModeOauth) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
 	session := sessions.Default(c)
 
-	randomBytes := make([]byte, 16)
-	crand.Read(randomBytes)
-	state := hex.EncodeToString(randomBytes)
-
-	session.Set(fmt.Sprintf("%s_state", provider.ID), state)
+	flow := newOauthFlow(c.Query("return_to"))
+	flow.save(session, provider.ID)
 	session.Save()
 
-	c.Redirect(http.StatusFound, provider.OauthConfig.AuthCodeURL(state))
+	var options []oauth2.AuthCodeOption
+	if provider.UsePkce() {
+		options = flow.authCodeOptions()
+	}
+
+	c.Redirect(http.StatusFound, provider.OauthConfig.AuthCodeURL(flow.State, options...))
 }
 
 func (p *poddy) oauthRedirectHandler(c *gin.Context) {
 	provider := p.getProviderForId(c.Param("id"))
-	if provider == nil {
+	if provider == nil || !provider.HasLoginMode(config.LoginModeOauth) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
 
-	stateKey := fmt.Sprintf("%s_state", provider.ID)
-
 	session := sessions.Default(c)
-	if c.Query("state") == "" || c.Query("state") != session.Get(stateKey) {
-		c.AbortWithStatus(http.StatusBadRequest)
+
+	flow := popOauthFlow(session, provider.ID, c.Query("state"))
+	session.Save()
+
+	if flow == nil {
+		c.AbortWithError(http.StatusBadRequest, errors.New("oauth state mismatch"))
+		return
 	}
 
-	session.Delete(stateKey)
+	var options []oauth2.AuthCodeOption
+	if provider.UsePkce() {
+		options = flow.exchangeOptions()
+	}
 
-	token, err := provider.OauthConfig.Exchange(context.Background(), c.Query("code"))
+	token, err := provider.OauthConfig.Exchange(c.Request.Context(), c.Query("code"), options...)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to exchange code for token: %v\n", err))
 		return
@@ -70,9 +86,23 @@ func (p *poddy) oauthRedirectHandler(c *gin.Context) {
 		return
 	}
 
+	repositoryProvider, err := provider.GetRepositoryProvider(tokenSource)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
+		return
+	}
+
+	selfUser, err := repositoryProvider.GetSelfUser(c.Request.Context())
+	if err != nil {
+		abortWithProviderError(c, provider, err, "failed to get current user")
+		return
+	}
+
+	SaveUserToSession(session, provider, selfUser.GetId(), selfUser.GetUsername())
+	sessionstore.RegenerateId(session)
 	session.Save()
 
-	c.Redirect(http.StatusFound, "/")
+	c.Redirect(http.StatusFound, flow.ReturnTo)
 }
 
 func (p *poddy) oauthLogoutHandler(c *gin.Context) {
@@ -84,39 +114,186 @@ func (p *poddy) oauthLogoutHandler(c *gin.Context) {
 
 	session := sessions.Default(c)
 	RemoveTokenFromSession(session, provider)
+	RemoveCredentialsFromSession(session, provider)
+	RemoveUserFromSession(session, provider)
 	session.Save()
 
 	c.Redirect(http.StatusFound, "/")
 }
 
-func (p *poddy) selfHandler(c *gin.Context) {
+type credentialsLoginBody struct {
+	Username      string `json:"username" binding:"required"`
+	Password      string `json:"password"`
+	SshPrivateKey string `json:"ssh_private_key"`
+	DisplayName   string `json:"display_name"`
+	Email         string `json:"email"`
+}
+
+func (p *poddy) credentialsLoginHandler(c *gin.Context) {
+	provider := p.getProviderForId(c.Param("id"))
+	if provider == nil || !provider.HasLoginMode(config.LoginModeCredentials) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	var body credentialsLoginBody
+
+	if err := c.ShouldBind(&body); err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to bind request body: %v", err))
+		return
+	}
+
+	if len(body.Password) == 0 && len(body.SshPrivateKey) == 0 {
+		c.AbortWithError(http.StatusBadRequest, errors.New("either a password or an ssh key is required"))
+		return
+	}
+
+	credentials := &models.UserCredentials{
+		Username:      body.Username,
+		Password:      body.Password,
+		SshPrivateKey: body.SshPrivateKey,
+		DisplayName:   body.DisplayName,
+		Email:         body.Email,
+	}
+
+	repositoryProvider, err := provider.GetCredentialsRepositoryProvider(credentials)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
+		return
+	}
+
+	// the user is whoever the remote accepted the credentials for
+	selfUser, err := repositoryProvider.GetSelfUser(c.Request.Context())
+	if err != nil {
+		abortWithProviderError(c, provider, err, "failed to verify credentials")
+		return
+	}
+
 	session := sessions.Default(c)
+	if err := SaveCredentialsToSession(session, provider, credentials); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to save credentials to session: %v", err))
+		return
+	}
 
-	currentSessions := make([]interface{}, 0)
+	SaveUserToSession(session, provider, selfUser.GetId(), selfUser.GetUsername())
+	sessionstore.RegenerateId(session)
+	session.Save()
 
-	for _, providerConfig := range p.oauthRepositoryProviderConfigs {
-		tokenSource, err := ReadTokenFromSession(session, &providerConfig)
-		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
+	c.Status(http.StatusNoContent)
+}
+
+type tokenLoginBody struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// tokenLoginHandler logs in with a personal access token, for providers that
+// have no OAuth application or clients that can't follow a browser redirect.
+func (p *poddy) tokenLoginHandler(c *gin.Context) {
+	provider := p.getProviderForId(c.Param("id"))
+	if provider == nil || !provider.HasLoginMode(config.LoginModeToken) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	var body tokenLoginBody
+
+	if err := c.ShouldBind(&body); err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("failed to bind request body: %v", err))
+		return
+	}
+
+	tokenSource := oauth2.StaticTokenSource(&oauth2.Token{
+		AccessToken: body.Token,
+		TokenType:   "Bearer",
+	})
+
+	repositoryProvider, err := provider.GetRepositoryProvider(tokenSource)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
+		return
+	}
+
+	selfUser, err := repositoryProvider.GetSelfUser(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, models.ErrUnauthorized) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, map[string]interface{}{
+				"error": "invalid token",
+			})
 			return
 		}
 
-		if tokenSource == nil {
-			continue
+		abortWithProviderError(c, provider, err, "failed to get current user")
+		return
+	}
+
+	// tokens of providers that can't tell their scopes are accepted, but the
+	// client is told that they may lack some
+	scopesVerified := false
+	if scopeChecker, ok := repositoryProvider.(models.TokenScopeChecker); ok {
+		missingScopes, err := scopeChecker.MissingTokenScopes(c.Request.Context(), provider.RequiredScopes())
+		if err != nil && !errors.Is(err, models.ErrScopesUnverified) {
+			abortWithProviderError(c, provider, err, "failed to check token scopes")
+			return
 		}
 
-		repositoryProvider, err := providerConfig.GetRepositoryProvider(tokenSource)
+		if len(missingScopes) > 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, map[string]interface{}{
+				"error":          "token is missing required scopes",
+				"missing_scopes": missingScopes,
+			})
+			return
+		}
+
+		scopesVerified = err == nil
+	}
+
+	session := sessions.Default(c)
+	if err := SaveTokenToSession(session, provider, tokenSource); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to save token to session: %v", err))
+		return
+	}
+
+	SaveUserToSession(session, provider, selfUser.GetId(), selfUser.GetUsername())
+	sessionstore.RegenerateId(session)
+	session.Save()
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"host":            provider.Host,
+		"provider":        provider.ID,
+		"scopes_verified": scopesVerified,
+		"user": map[string]interface{}{
+			"username":     selfUser.GetUsername(),
+			"display_name": selfUser.GetDisplayName(),
+			"email":        selfUser.GetEmail(),
+			"avatar_url":   selfUser.GetAvatarUrl(),
+		},
+	})
+}
+
+// selfHandler returns the poddy user, if logged in through OIDC, and the
+// repository provider accounts linked to the session.
+func (p *poddy) selfHandler(c *gin.Context) {
+	session := sessions.Default(c)
+
+	accounts := make([]interface{}, 0)
+
+	for _, providerConfig := range p.oauthRepositoryProviderConfigs {
+		repositoryProvider, err := getSessionRepositoryProvider(session, &providerConfig)
 		if err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
 			return
 		}
 
-		selfUser, err := repositoryProvider.GetSelfUser()
+		if repositoryProvider == nil {
+			continue
+		}
+
+		selfUser, err := repositoryProvider.GetSelfUser(c.Request.Context())
 		if err != nil {
 			continue
 		}
 
-		currentSessions = append(currentSessions, map[string]interface{}{
+		accounts = append(accounts, map[string]interface{}{
 			"host":     providerConfig.Host,
 			"provider": providerConfig.ID,
 			"user": map[string]interface{}{
@@ -128,13 +305,158 @@ func (p *poddy) selfHandler(c *gin.Context) {
 		})
 	}
 
-	c.JSON(http.StatusOK, currentSessions)
+	var user interface{}
+
+	poddyUser, err := ReadPoddyUserFromSession(session)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if poddyUser != nil {
+		user = map[string]interface{}{
+			"id":           poddyUser.ID(),
+			"username":     poddyUser.Username,
+			"display_name": poddyUser.Name,
+			"email":        poddyUser.Email,
+		}
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"user":     user,
+		"accounts": accounts,
+	})
+}
+
+// getWorkspaceOwner returns the owner the workspaces of the current user are
+// labelled with and relabels the user's workspaces of earlier versions.
+func getWorkspaceOwner(ctx context.Context, session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig, currentUser models.User) (*workspaceOwner, error) {
+	poddyUser, err := ReadPoddyUserFromSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	owner := &workspaceOwner{
+		ProviderID: providerConfig.ID,
+		UserID:     currentUser.GetId(),
+		Username:   currentUser.GetUsername(),
+		PoddyUser:  poddyUser,
+	}
+
+	if err := claimLegacyWorkspaces(ctx, owner, providerConfig.Host); err != nil {
+		log.Printf("failed to claim legacy workspaces of %s: %v\n", providerConfig.ID, err)
+	}
+
+	return owner, nil
+}
+
+func parsePageQuery(c *gin.Context) (int, error) {
+	pageQuery := c.DefaultQuery("page", "1")
+
+	page, err := strconv.Atoi(pageQuery)
+	if err != nil || page < 1 {
+		return 0, fmt.Errorf("invalid page: %s", pageQuery)
+	}
+
+	return page, nil
+}
+
+func (p *poddy) listProjectsHandler(c *gin.Context) {
+	repositoryProviderConfig := p.getProviderForId(c.Param("id"))
+	if repositoryProviderConfig == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	page, err := parsePageQuery(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	session := sessions.Default(c)
+	repositoryProvider, err := getSessionRepositoryProvider(session, repositoryProviderConfig)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
+		return
+	}
+
+	if repositoryProvider == nil {
+		abortWithLoginRequired(c, repositoryProviderConfig, nil)
+		return
+	}
+
+	projects, nextPage, err := repositoryProvider.ListProjects(c.Request.Context(), c.Query("search"), page)
+	if err != nil {
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to list projects")
+		return
+	}
+
+	items := make([]map[string]interface{}, len(projects))
+	for i, project := range projects {
+		items[i] = map[string]interface{}{
+			"full_name":      project.GetFullName(),
+			"default_branch": project.GetDefaultBranch(),
+		}
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"items":     items,
+		"next_page": nextPage,
+	})
+}
+
+func (p *poddy) listBranchesHandler(c *gin.Context) {
+	repositoryProviderConfig := p.getProviderForId(c.Param("id"))
+	if repositoryProviderConfig == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	page, err := parsePageQuery(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	session := sessions.Default(c)
+	repositoryProvider, err := getSessionRepositoryProvider(session, repositoryProviderConfig)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
+		return
+	}
+
+	if repositoryProvider == nil {
+		abortWithLoginRequired(c, repositoryProviderConfig, nil)
+		return
+	}
+
+	branches, nextPage, err := repositoryProvider.ListBranches(c.Request.Context(), c.Param("slug"), c.Query("search"), page)
+	if err != nil {
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to list branches")
+		return
+	}
+
+	items := make([]map[string]interface{}, len(branches))
+	for i, branch := range branches {
+		items[i] = map[string]interface{}{
+			"name": branch.GetName(),
+		}
+	}
+
+	c.JSON(http.StatusOK, map[string]interface{}{
+		"items":     items,
+		"next_page": nextPage,
+	})
 }
 
 type openWorkspaceBody struct {
 	Host    string `json:"host" binding:"required"`
 	Project string `json:"project" binding:"required"`
-	Branch  string `json:"branch" binding:"required"`
+	Branch  string `json:"branch"`
+	Ref     string `json:"ref"`
+
+	MergeRequest int `json:"merge_request"`
 }
 
 func (p *poddy) openWorkspaceHandler(c *gin.Context) {
@@ -145,6 +467,15 @@ func (p *poddy) openWorkspaceHandler(c *gin.Context) {
 		return
 	}
 
+	if len(body.Ref) == 0 {
+		body.Ref = body.Branch
+	}
+
+	if body.MergeRequest < 0 || (body.MergeRequest > 0 && len(body.Ref) > 0) {
+		c.AbortWithError(http.StatusBadRequest, errors.New("either a ref or a merge request can be given"))
+		return
+	}
+
 	repositoryProviderConfig := p.getProviderForHost(body.Host)
 	if repositoryProviderConfig == nil {
 		c.AbortWithStatus(http.StatusBadRequest)
@@ -152,38 +483,38 @@ func (p *poddy) openWorkspaceHandler(c *gin.Context) {
 	}
 
 	session := sessions.Default(c)
-	tokenSource, err := ReadTokenFromSession(session, repositoryProviderConfig)
+	repositoryProvider, err := getSessionRepositoryProvider(session, repositoryProviderConfig)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to read token from session: %v", err))
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
 		return
 	}
 
-	if tokenSource == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+	if repositoryProvider == nil {
+		abortWithLoginRequired(c, repositoryProviderConfig, nil)
 		return
 	}
 
-	repositoryProvider, err := repositoryProviderConfig.GetRepositoryProvider(tokenSource)
+	currentUser, err := repositoryProvider.GetSelfUser(c.Request.Context())
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to get current user")
 		return
 	}
 
-	currentUser, err := repositoryProvider.GetSelfUser()
+	gitCredentials, err := getSessionGitCredentials(session, repositoryProviderConfig)
 	if err != nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to get git credentials")
 		return
 	}
 
-	token, err := tokenSource.Token()
+	owner, err := getWorkspaceOwner(c.Request.Context(), session, repositoryProviderConfig, currentUser)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get access token: %v", err))
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get workspace owner: %v", err))
 		return
 	}
 
-	workspaceName, workspaceUrl, err := createWorkspace(repositoryProvider, body.Project, body.Branch, currentUser, token.AccessToken)
+	workspaceName, workspaceUrl, err := createWorkspace(c.Request.Context(), repositoryProvider, currentUser, body.Project, body.Ref, body.MergeRequest, owner, gitCredentials)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to create workspace: %v", err))
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to create workspace")
 		return
 	}
 
@@ -193,43 +524,66 @@ func (p *poddy) openWorkspaceHandler(c *gin.Context) {
 	})
 }
 
-func (p *poddy) listWorkspacesHandler(c *gin.Context) {
-	workspaces := make(map[string][]map[string]string)
+// sessionWorkspaceOwners returns the workspace owners of all providers the
+// session is logged in to. Providers that fail to return the current user are
+// skipped.
+func (p *poddy) sessionWorkspaceOwners(c *gin.Context) ([]*workspaceOwner, error) {
+	owners := make([]*workspaceOwner, 0)
 
 	for _, providerConfig := range p.oauthRepositoryProviderConfigs {
 		session := sessions.Default(c)
-		tokenSource, err := ReadTokenFromSession(session, &providerConfig)
+		repositoryProvider, err := getSessionRepositoryProvider(session, &providerConfig)
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to read token from session: %v", err))
-			return
+			return nil, fmt.Errorf("failed to get repository provider: %v", err)
 		}
 
-		if tokenSource == nil {
+		if repositoryProvider == nil {
 			continue
 		}
 
-		repositoryProvider, err := providerConfig.GetRepositoryProvider(tokenSource)
+		currentUser, err := repositoryProvider.GetSelfUser(c.Request.Context())
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
-			return
+			continue
 		}
 
-		currentUser, err := repositoryProvider.GetSelfUser()
+		owner, err := getWorkspaceOwner(c.Request.Context(), session, &providerConfig, currentUser)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("failed to get workspace owner: %v", err)
 		}
 
-		list, err := listWorkspaces(currentUser)
+		owners = append(owners, owner)
+	}
+
+	return owners, nil
+}
+
+func (p *poddy) listWorkspacesHandler(c *gin.Context) {
+	query, err := parseWorkspaceListQuery(c)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	owners, err := p.sessionWorkspaceOwners(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	workspaces := make([]workspaceInfo, 0)
+
+	for _, owner := range owners {
+		list, err := listWorkspaces(c.Request.Context(), owner)
 		if err != nil {
 			continue
 		}
 
-		if len(list) > 0 {
-			workspaces[providerConfig.ID] = list
-		}
+		workspaces = append(workspaces, list...)
 	}
 
-	c.JSON(http.StatusOK, workspaces)
+	c.JSON(http.StatusOK, &workspaceListResponse{
+		Items: query.apply(workspaces),
+	})
 }
 
 func (p *poddy) deleteWorkspaceHandler(c *gin.Context) {
@@ -240,33 +594,141 @@ func (p *poddy) deleteWorkspaceHandler(c *gin.Context) {
 	}
 
 	session := sessions.Default(c)
-	tokenSource, err := ReadTokenFromSession(session, repositoryProviderConfig)
+	repositoryProvider, err := getSessionRepositoryProvider(session, repositoryProviderConfig)
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to read token from session: %v", err))
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
 		return
 	}
 
-	if tokenSource == nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+	if repositoryProvider == nil {
+		abortWithLoginRequired(c, repositoryProviderConfig, nil)
 		return
 	}
 
-	repositoryProvider, err := repositoryProviderConfig.GetRepositoryProvider(tokenSource)
+	currentUser, err := repositoryProvider.GetSelfUser(c.Request.Context())
 	if err != nil {
-		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to get current user")
 		return
 	}
 
-	currentUser, err := repositoryProvider.GetSelfUser()
+	owner, err := getWorkspaceOwner(c.Request.Context(), session, repositoryProviderConfig, currentUser)
 	if err != nil {
-		c.AbortWithStatus(http.StatusUnauthorized)
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get workspace owner: %v", err))
 		return
 	}
 
-	if err := deleteWorkspace(c.Param("name"), currentUser); err != nil {
-		c.AbortWithError(http.StatusInternalServerError, err)
+	if err := deleteWorkspace(c.Request.Context(), c.Param("name"), owner); err != nil {
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to delete workspace")
 		return
 	}
 
 	c.Status(http.StatusNoContent)
 }
+
+func (p *poddy) getWorkspaceHandler(c *gin.Context) {
+	repositoryProviderConfig := p.getProviderForId(c.Param("provider"))
+	if repositoryProviderConfig == nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	session := sessions.Default(c)
+	repositoryProvider, err := getSessionRepositoryProvider(session, repositoryProviderConfig)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
+		return
+	}
+
+	if repositoryProvider == nil {
+		abortWithLoginRequired(c, repositoryProviderConfig, nil)
+		return
+	}
+
+	currentUser, err := repositoryProvider.GetSelfUser(c.Request.Context())
+	if err != nil {
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to get current user")
+		return
+	}
+
+	owner, err := getWorkspaceOwner(c.Request.Context(), session, repositoryProviderConfig, currentUser)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get workspace owner: %v", err))
+		return
+	}
+
+	workspace, err := getWorkspace(c.Request.Context(), c.Param("name"), owner)
+	if err != nil {
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to get workspace")
+		return
+	}
+
+	c.JSON(http.StatusOK, workspace)
+}
+
+func (p *poddy) workspaceLogsHandler(c *gin.Context) {
+	repositoryProviderConfig := p.getProviderForId(c.Param("provider"))
+	if repositoryProviderConfig == nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	session := sessions.Default(c)
+	repositoryProvider, err := getSessionRepositoryProvider(session, repositoryProviderConfig)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get repository provider: %v", err))
+		return
+	}
+
+	if repositoryProvider == nil {
+		abortWithLoginRequired(c, repositoryProviderConfig, nil)
+		return
+	}
+
+	currentUser, err := repositoryProvider.GetSelfUser(c.Request.Context())
+	if err != nil {
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to get current user")
+		return
+	}
+
+	owner, err := getWorkspaceOwner(c.Request.Context(), session, repositoryProviderConfig, currentUser)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to get workspace owner: %v", err))
+		return
+	}
+
+	// nginx would otherwise buffer the event stream
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	logs, err := workspaceLogs(c.Request.Context(), c.Param("name"), owner, c.Query("container"), c.Query("follow") == "true", func(status workspaceStatus, reason string) {
+		c.SSEvent("status", gin.H{"status": status, "reason": reason})
+		c.Writer.Flush()
+	})
+	if err != nil {
+		// the stream has already started while waiting for the container
+		if c.Writer.Written() {
+			c.Error(err)
+			c.SSEvent("error", err.Error())
+			return
+		}
+
+		abortWithProviderError(c, repositoryProviderConfig, err, "failed to get workspace logs")
+		return
+	}
+
+	defer logs.Close()
+
+	err = logs.each(func(line string) bool {
+		c.SSEvent("log", line)
+		c.Writer.Flush()
+
+		return c.Request.Context().Err() == nil
+	})
+	if err != nil && c.Request.Context().Err() == nil {
+		c.Error(err)
+		c.SSEvent("error", fmt.Sprintf("failed to read logs: %v", err))
+		return
+	}
+
+	c.SSEvent("end", "")
+}
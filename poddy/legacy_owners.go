@@ -0,0 +1,196 @@
+package poddy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/dogboy21/poddy/config"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Workspaces created by earlier versions carry their owner in plain labels:
+// workspace-owner holds the provider username and, with an OIDC login,
+// workspace-user the poddy user and workspace-provider the provider id. They
+// are relabelled to the hashed owner labels once their owner shows up with an
+// identity the provider or the OIDC login verified. Workspaces labelled with
+// just the username are only claimed by the account of that name at the
+// provider whose host the workspace's repository was cloned from.
+
+// the owners whose legacy workspaces have been relabelled since poddy started
+var claimedLegacyOwners sync.Map
+
+// legacyWorkspaceSelector selects the workspaces of the owner that still
+// carry the plain owner labels.
+func legacyWorkspaceSelector(owner *workspaceOwner) (labels.Selector, error) {
+	requirements := make([]labels.Requirement, 0, 3)
+
+	add := func(key string, op selection.Operator, values ...string) error {
+		requirement, err := labels.NewRequirement(key, op, values)
+		if err != nil {
+			return err
+		}
+
+		requirements = append(requirements, *requirement)
+		return nil
+	}
+
+	var err error
+	if owner.PoddyUser != nil {
+		err = add("workspace-user", selection.Equals, owner.PoddyUser.ID())
+		if err == nil {
+			err = add("workspace-provider", selection.Equals, owner.ProviderID)
+		}
+	} else {
+		err = add("workspace-owner", selection.Equals, owner.Username)
+		if err == nil {
+			err = add("workspace-provider", selection.DoesNotExist)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := add("managed-by", selection.Equals, "poddy"); err != nil {
+		return nil, err
+	}
+
+	return labels.NewSelector().Add(requirements...), nil
+}
+
+// clonedFromHost reports whether the workspace's repository was cloned from
+// the provider host.
+func clonedFromHost(deployment *appsv1.Deployment, providerHost string) bool {
+	if hostname, _, err := net.SplitHostPort(providerHost); err == nil {
+		providerHost = hostname
+	}
+
+	podSpec := deployment.Spec.Template.Spec
+	for _, container := range append(append([]corev1.Container{}, podSpec.InitContainers...), podSpec.Containers...) {
+		for _, env := range container.Env {
+			if env.Name == "GIT_HOST" {
+				return strings.EqualFold(env.Value, providerHost)
+			}
+		}
+	}
+
+	return false
+}
+
+// relabelWorkspace replaces the plain owner labels of the workspace's
+// deployment, service and ingress with the hashed ones. The selector and the
+// pod template are immutable or would restart the workspace, so the pods keep
+// their labels.
+func relabelWorkspace(ctx context.Context, clientSet kubernetes.Interface, workspaceName string, owner *workspaceOwner) error {
+	ownerLabels := map[string]interface{}{
+		"workspace-user": nil,
+	}
+
+	for k, v := range owner.labels() {
+		ownerLabels[k] = v
+	}
+
+	objectPatch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": ownerLabels,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode patch: %w", err)
+	}
+
+	deploymentPatch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      ownerLabels,
+			"annotations": owner.annotations(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode patch: %w", err)
+	}
+
+	namespace := config.DeploymentNamespace()
+
+	// the service and ingress come first, so the workspace keeps its old
+	// labels and is claimed again should relabelling fail half way
+	_, err = clientSet.CoreV1().Services(namespace).Patch(ctx, workspaceName, types.MergePatchType, objectPatch, metav1.PatchOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to relabel service: %w", err)
+	}
+
+	_, err = clientSet.NetworkingV1().Ingresses(namespace).Patch(ctx, workspaceName, types.MergePatchType, objectPatch, metav1.PatchOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to relabel ingress: %w", err)
+	}
+
+	_, err = clientSet.AppsV1().Deployments(namespace).Patch(ctx, workspaceName, types.MergePatchType, deploymentPatch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to relabel deployment: %w", err)
+	}
+
+	return nil
+}
+
+// claimLegacyWorkspaces relabels the workspaces the owner created with an
+// earlier version. It only runs once per owner and poddy instance unless it
+// fails.
+func claimLegacyWorkspaces(ctx context.Context, owner *workspaceOwner, providerHost string) error {
+	// poddy users own workspaces of several providers under the same hash
+	claimKey := owner.ownerHash() + "|" + owner.ProviderID
+	if _, claimed := claimedLegacyOwners.Load(claimKey); claimed {
+		return nil
+	}
+
+	selector, err := legacyWorkspaceSelector(owner)
+	if err != nil {
+		// identities that aren't valid label values can't own legacy
+		// workspaces
+		claimedLegacyOwners.Store(claimKey, struct{}{})
+		return nil
+	}
+
+	kubernetesConfig, err := getKubernetesConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get Kubernetes config: %w", err)
+	}
+
+	clientSet, err := kubernetes.NewForConfig(kubernetesConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	deploymentList, err := clientSet.AppsV1().Deployments(config.DeploymentNamespace()).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list legacy deployments: %w", err)
+	}
+
+	for _, deployment := range deploymentList.Items {
+		if owner.PoddyUser == nil && !clonedFromHost(&deployment, providerHost) {
+			continue
+		}
+
+		if err := relabelWorkspace(ctx, clientSet, deployment.Name, owner); err != nil {
+			return fmt.Errorf("failed to relabel workspace %s: %w", deployment.Name, err)
+		}
+
+		log.Printf("relabelled legacy workspace %s of %s\n", deployment.Name, owner.ProviderID)
+	}
+
+	claimedLegacyOwners.Store(claimKey, struct{}{})
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package poddy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	"golang.org/x/oauth2"
+)
+
+// oauthFlow is what an authorization code flow has to remember in the session
+// between sending the user to the provider and the provider redirecting back.
+type oauthFlow struct {
+	State        string
+	CodeVerifier string
+	ReturnTo     string
+}
+
+func newOauthFlow(returnTo string) *oauthFlow {
+	return &oauthFlow{
+		State:        randomHex(16),
+		CodeVerifier: base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32)),
+		ReturnTo:     sanitizeReturnTo(returnTo),
+	}
+}
+
+// sanitizeReturnTo only lets through paths on poddy itself, so the login can't
+// be abused as an open redirect.
+func sanitizeReturnTo(returnTo string) string {
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.Contains(returnTo, "\\") {
+		return "/"
+	}
+
+	parsedUrl, err := url.Parse(returnTo)
+	if err != nil || parsedUrl.IsAbs() || len(parsedUrl.Host) > 0 {
+		return "/"
+	}
+
+	return returnTo
+}
+
+// authCodeOptions returns the PKCE (S256) challenge for the authorization
+// request.
+func (f *oauthFlow) authCodeOptions() []oauth2.AuthCodeOption {
+	challenge := sha256.Sum256([]byte(f.CodeVerifier))
+
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+}
+
+func (f *oauthFlow) exchangeOptions() []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_verifier", f.CodeVerifier),
+	}
+}
+
+func (f *oauthFlow) save(session sessions.Session, prefix string) {
+	session.Set(prefix+"_state", f.State)
+	session.Set(prefix+"_code_verifier", f.CodeVerifier)
+	session.Set(prefix+"_return_to", f.ReturnTo)
+}
+
+// popOauthFlow removes the flow from the session and returns it, provided the
+// state matches the one the provider passed back.
+func popOauthFlow(session sessions.Session, prefix, state string) *oauthFlow {
+	flow := &oauthFlow{}
+	flow.State, _ = session.Get(prefix + "_state").(string)
+	flow.CodeVerifier, _ = session.Get(prefix + "_code_verifier").(string)
+	flow.ReturnTo, _ = session.Get(prefix + "_return_to").(string)
+
+	session.Delete(prefix + "_state")
+	session.Delete(prefix + "_code_verifier")
+	session.Delete(prefix + "_return_to")
+
+	if len(state) == 0 || state != flow.State {
+		return nil
+	}
+
+	flow.ReturnTo = sanitizeReturnTo(flow.ReturnTo)
+
+	return flow
+}
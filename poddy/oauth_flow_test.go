@@ -0,0 +1,27 @@
+package poddy
+
+import "testing"
+
+func TestSanitizeReturnTo(t *testing.T) {
+	tests := []struct {
+		returnTo string
+		want     string
+	}{
+		{returnTo: "/", want: "/"},
+		{returnTo: "/workspaces?page=2#top", want: "/workspaces?page=2#top"},
+		{returnTo: "", want: "/"},
+		{returnTo: "workspaces", want: "/"},
+		{returnTo: "https://evil.example.com/", want: "/"},
+		{returnTo: "//evil.example.com/", want: "/"},
+		{returnTo: "/\\evil.example.com", want: "/"},
+		{returnTo: "/%zz", want: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.returnTo, func(t *testing.T) {
+			if got := sanitizeReturnTo(tt.returnTo); got != tt.want {
+				t.Errorf("sanitizeReturnTo(%q) = %q, want %q", tt.returnTo, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+package poddy
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/dogboy21/poddy/config"
+	"github.com/dogboy21/poddy/sessionstore"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+const (
+	sessionPoddyUserKey = "poddy_user"
+	sessionOidcNonceKey = "oidc_nonce"
+)
+
+// poddyUser is the identity of a user logged in through the OIDC provider.
+// Repository providers are linked to it as accounts.
+type poddyUser struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+}
+
+// ID returns a stable and label safe identifier of the user.
+func (u *poddyUser) ID() string {
+	hash := sha256.Sum256([]byte(u.Issuer + "|" + u.Subject))
+	return hex.EncodeToString(hash[:20])
+}
+
+func SavePoddyUserToSession(session sessions.Session, user *poddyUser) error {
+	jsonUser, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	session.Set(sessionPoddyUserKey, string(jsonUser))
+
+	return nil
+}
+
+func ReadPoddyUserFromSession(session sessions.Session) (*poddyUser, error) {
+	return decodePoddyUser(session.Get(sessionPoddyUserKey))
+}
+
+func decodePoddyUser(value interface{}) (*poddyUser, error) {
+	sessionValue, ok := value.(string)
+	if !ok {
+		return nil, nil
+	}
+
+	user := &poddyUser{}
+	if err := json.Unmarshal([]byte(sessionValue), user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user: %v", err)
+	}
+
+	return user, nil
+}
+
+type oidcLogin struct {
+	oauthConfig   *oauth2.Config
+	verifier      *oidc.IDTokenVerifier
+	usernameClaim string
+}
+
+// newOidcLogin discovers the configured OIDC provider. A nil login is returned
+// if no issuer is configured, in which case the repository provider logins
+// are the only identity.
+func newOidcLogin() (*oidcLogin, error) {
+	if !config.OidcEnabled() {
+		return nil, nil
+	}
+
+	if len(config.OidcClientId()) == 0 {
+		return nil, errors.New("oidc.clientId is required")
+	}
+
+	// the context is kept for fetching the signing keys later on
+	ctx := oidc.ClientContext(context.Background(), &http.Client{
+		Timeout: config.TimeoutsProviderRequest(),
+	})
+
+	provider, err := oidc.NewProvider(ctx, config.OidcIssuerUrl())
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover oidc provider %s: %w", config.OidcIssuerUrl(), err)
+	}
+
+	return &oidcLogin{
+		oauthConfig: &oauth2.Config{
+			ClientID:     config.OidcClientId(),
+			ClientSecret: config.OidcClientSecret(),
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  config.ServerUrl().ResolveReference(&url.URL{Path: "/auth/callback"}).String(),
+			Scopes:       config.OidcScopes(),
+		},
+		verifier: provider.Verifier(&oidc.Config{
+			ClientID: config.OidcClientId(),
+		}),
+		usernameClaim: config.OidcUsernameClaim(),
+	}, nil
+}
+
+func randomHex(length int) string {
+	randomBytes := make([]byte, length)
+	crand.Read(randomBytes)
+	return hex.EncodeToString(randomBytes)
+}
+
+// requireUser rejects requests without a poddy login if OIDC is enabled.
+// Browsers are sent to the login page, API clients get a 401.
+func (p *poddy) requireUser(c *gin.Context) {
+	if p.oidc == nil {
+		return
+	}
+
+	user, err := ReadPoddyUserFromSession(sessions.Default(c))
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if user != nil {
+		return
+	}
+
+	if c.Request.Method == http.MethodGet && !strings.HasPrefix(c.Request.URL.Path, "/api/") {
+		c.Redirect(http.StatusFound, "/auth/login?return_to="+url.QueryEscape(c.Request.URL.RequestURI()))
+		c.Abort()
+		return
+	}
+
+	c.AbortWithStatusJSON(http.StatusUnauthorized, map[string]interface{}{
+		"error":     "login required",
+		"login_url": "/auth/login",
+	})
+}
+
+func (p *poddy) oidcLoginHandler(c *gin.Context) {
+	if p.oidc == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	nonce := randomHex(16)
+
+	session := sessions.Default(c)
+	flow := newOauthFlow(c.Query("return_to"))
+	flow.save(session, "oidc")
+	session.Set(sessionOidcNonceKey, nonce)
+	session.Save()
+
+	options := append(flow.authCodeOptions(), oidc.Nonce(nonce))
+	c.Redirect(http.StatusFound, p.oidc.oauthConfig.AuthCodeURL(flow.State, options...))
+}
+
+func (p *poddy) oidcCallbackHandler(c *gin.Context) {
+	if p.oidc == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	session := sessions.Default(c)
+	nonce := session.Get(sessionOidcNonceKey)
+	session.Delete(sessionOidcNonceKey)
+
+	flow := popOauthFlow(session, "oidc", c.Query("state"))
+	session.Save()
+
+	if flow == nil {
+		c.AbortWithError(http.StatusBadRequest, errors.New("oidc state mismatch"))
+		return
+	}
+
+	token, err := p.oidc.oauthConfig.Exchange(c.Request.Context(), c.Query("code"), flow.exchangeOptions()...)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to exchange code for token: %v", err))
+		return
+	}
+
+	rawIdToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		c.AbortWithError(http.StatusInternalServerError, errors.New("token response did not contain an id token"))
+		return
+	}
+
+	idToken, err := p.oidc.verifier.Verify(c.Request.Context(), rawIdToken)
+	if err != nil {
+		c.AbortWithError(http.StatusUnauthorized, fmt.Errorf("failed to verify id token: %v", err))
+		return
+	}
+
+	if idToken.Nonce != nonce {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("id token nonce does not match"))
+		return
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to parse id token claims: %v", err))
+		return
+	}
+
+	user := &poddyUser{
+		Issuer:  idToken.Issuer,
+		Subject: idToken.Subject,
+	}
+	user.Username, _ = claims[p.oidc.usernameClaim].(string)
+	user.Name, _ = claims["name"].(string)
+	user.Email, _ = claims["email"].(string)
+
+	if len(user.Username) == 0 {
+		user.Username = user.Subject
+	}
+
+	// accounts linked by someone else must not carry over to this user
+	if previousUser, _ := ReadPoddyUserFromSession(session); previousUser != nil && previousUser.ID() != user.ID() {
+		session.Clear()
+	}
+
+	if err := SavePoddyUserToSession(session, user); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to save user to session: %v", err))
+		return
+	}
+
+	sessionstore.RegenerateId(session)
+	session.Save()
+
+	c.Redirect(http.StatusFound, flow.ReturnTo)
+}
+
+func (p *poddy) oidcLogoutHandler(c *gin.Context) {
+	session := sessions.Default(c)
+	options := sessionOptions()
+	options.MaxAge = -1
+
+	session.Clear()
+	session.Options(options)
+	session.Save()
+
+	c.Redirect(http.StatusFound, "/")
+}
@@ -0,0 +1,65 @@
+package poddy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// workspaceOwner identifies who a workspace belongs to. With an OIDC login the
+// workspaces belong to the poddy user, otherwise to the provider account. The
+// owner is stored hashed in the labels, which keeps the values label safe and
+// accounts of different providers apart, and readable in the annotations.
+type workspaceOwner struct {
+	ProviderID string
+	UserID     string
+	Username   string
+	PoddyUser  *poddyUser
+}
+
+// labelHash hashes the value into a label value.
+func labelHash(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:20])
+}
+
+func (o *workspaceOwner) ownerHash() string {
+	if o.PoddyUser != nil {
+		return labelHash("poddy|" + o.PoddyUser.ID())
+	}
+
+	return labelHash("provider|" + o.ProviderID + "|" + o.UserID)
+}
+
+func (o *workspaceOwner) labels() map[string]string {
+	return map[string]string{
+		"workspace-owner":    o.ownerHash(),
+		"workspace-provider": labelHash(o.ProviderID),
+	}
+}
+
+func (o *workspaceOwner) annotations() map[string]string {
+	annotations := map[string]string{
+		"workspace-owner-provider": o.ProviderID,
+		"workspace-owner-user-id":  o.UserID,
+		"workspace-owner-username": o.Username,
+	}
+
+	if o.PoddyUser != nil {
+		annotations["workspace-owner-poddy-user"] = o.PoddyUser.Username
+	}
+
+	return annotations
+}
+
+func (o *workspaceOwner) labelSelector() string {
+	return fmt.Sprintf("managed-by=poddy,workspace-owner=%s,workspace-provider=%s", o.ownerHash(), labelHash(o.ProviderID))
+}
+
+// owns reports whether the workspace with the given labels was created by the
+// owner through the owner's provider.
+func (o *workspaceOwner) owns(labels map[string]string) bool {
+	return labels["managed-by"] == "poddy" &&
+		labels["workspace-owner"] == o.ownerHash() &&
+		labels["workspace-provider"] == labelHash(o.ProviderID)
+}
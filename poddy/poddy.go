@@ -7,14 +7,18 @@ import (
 	"time"
 
 	"github.com/dogboy21/poddy/config"
+	"github.com/dogboy21/poddy/sessionstore"
 	"github.com/gin-contrib/sessions"
-	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 )
 
 type poddy struct {
 	r                              *gin.Engine
 	oauthRepositoryProviderConfigs []config.OauthRepositoryProviderConfig
+	sessionStore                   *sessionstore.Store
+	oidc                           *oidcLogin
+	workspaceEndpoints             workspaceEndpointCache
+	workspaceWatcher               workspaceWatcher
 }
 
 func (p *poddy) getProviderForId(id string) *config.OauthRepositoryProviderConfig {
@@ -37,6 +41,16 @@ func (p *poddy) getProviderForHost(host string) *config.OauthRepositoryProviderC
 	return nil
 }
 
+func sessionOptions() sessions.Options {
+	return sessions.Options{
+		Secure:   config.ServerSecureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   24 * 60 * 60,
+		Path:     "/",
+	}
+}
+
 func Start() {
 	rand.Seed(time.Now().UnixNano())
 
@@ -50,42 +64,112 @@ func Start() {
 		log.Fatalf("failed to read oauth repository provider configs: %v\n", err)
 	}
 
+	keyPairs, err := cookieKeyPairs()
+	if err != nil {
+		log.Fatalf("failed to get cookie keys: %v\n", err)
+	}
+
+	setCredentialsKeys(keyPairs)
+	setWorkspaceGrantKeys(keyPairs)
+
+	sessionStore, serverSessionStore, err := newSessionStore(keyPairs)
+	if err != nil {
+		log.Fatalf("failed to create session store: %v\n", err)
+	}
+
+	sharedTokens = serverSessionStore
+
+	switch config.DeploymentAuth() {
+	case config.DeploymentAuthNginx, config.DeploymentAuthTraefik, config.DeploymentAuthProxy:
+	case config.DeploymentAuthNone:
+		log.Println("WARNING: workspaces are reachable by anyone as deployment.auth is none")
+	default:
+		log.Fatalf("unknown deployment.auth %s\n", config.DeploymentAuth())
+	}
+
+	oidcLogin, err := newOidcLogin()
+	if err != nil {
+		log.Fatalf("failed to set up oidc login: %v\n", err)
+	}
+
 	app := poddy{
 		r:                              gin.New(),
 		oauthRepositoryProviderConfigs: oauthRepositoryProviderConfigs,
+		sessionStore:                   serverSessionStore,
+		oidc:                           oidcLogin,
 	}
 
-	sessionStore := cookie.NewStore(config.ServerCookieSecret())
-	sessionStore.Options(sessions.Options{
-		Domain:   config.ServerUrl().Host,
-		Secure:   config.ServerSecureCookies(),
-		HttpOnly: true,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   24 * 60 * 60,
-		Path:     "/",
-	})
+	if serverSessionStore != nil {
+		serverSessionStore.SetOwners(app.sessionOwners)
+	}
+
+	go app.purgeExpiredSessions()
+
+	// project slugs contain slashes and are passed url-encoded as a single path segment
+	app.r.UseRawPath = true
+	app.r.UnescapePathValues = true
+
+	sessionStore.Options(sessionOptions())
 
 	app.r.Use(
 		gin.Logger(), gin.Recovery(),
 		sessions.Sessions("poddy", sessionStore),
 	)
 
+	app.r.GET("/auth/login", app.oidcLoginHandler)
+	app.r.GET("/auth/callback", app.oidcCallbackHandler)
+	app.r.GET("/auth/logout", app.oidcLogoutHandler)
+	app.r.GET("/auth/cli", app.requireUser, app.cliLoginHandler)
+	app.r.POST("/auth/cli", app.requireUser, app.cliLoginConsentHandler)
+	app.r.Any("/auth/workspace", app.forwardAuthHandler)
+	app.r.GET("/auth/workspace/signin", app.requireUser, app.forwardAuthSigninHandler)
+
 	app.r.GET("/oauth/providers", app.listOauthProvidersHandler)
-	app.r.GET("/oauth/auth/:id", app.oauthAuthHandler)
-	app.r.GET("/oauth/redirect/:id", app.oauthRedirectHandler)
+	app.r.GET("/oauth/auth/:id", app.requireUser, app.oauthAuthHandler)
+	app.r.GET("/oauth/redirect/:id", app.requireUser, app.oauthRedirectHandler)
 	app.r.GET("/oauth/logout/:id", app.oauthLogoutHandler)
+	app.r.POST("/oauth/credentials/:id", app.requireUser, app.credentialsLoginHandler)
+	app.r.POST("/oauth/token/:id", app.requireUser, app.tokenLoginHandler)
+
+	api := app.r.Group("/api/v1", app.bearerAuth, app.requireUser)
+
+	api.GET("/self", app.selfHandler)
+	api.GET("/sessions", requireCookieSession, app.listSessionsHandler)
+	api.DELETE("/sessions/:id", requireCookieSession, app.revokeSessionHandler)
+
+	api.POST("/tokens", requireCookieSession, app.createApiTokenHandler)
+	api.GET("/tokens", requireCookieSession, app.listApiTokensHandler)
+	api.DELETE("/tokens/:id", requireCookieSession, app.revokeApiTokenHandler)
+
+	api.GET("/providers/:id/projects", requireScope(ScopeProjectsRead), app.listProjectsHandler)
+	api.GET("/providers/:id/projects/:slug/branches", requireScope(ScopeProjectsRead), app.listBranchesHandler)
 
-	app.r.GET("/api/v1/self", app.selfHandler)
+	api.POST("/workspaces", requireScope(ScopeWorkspacesWrite), app.openWorkspaceHandler)
+	api.GET("/workspaces", requireScope(ScopeWorkspacesRead), app.listWorkspacesHandler)
+	api.GET("/workspaces/:provider/:name", requireScope(ScopeWorkspacesRead), app.getWorkspaceHandler)
+	api.DELETE("/workspaces/:provider/:name", requireScope(ScopeWorkspacesWrite), app.deleteWorkspaceHandler)
+	api.GET("/workspaces/:provider/:name/logs", requireScope(ScopeWorkspacesRead), app.workspaceLogsHandler)
 
-	app.r.POST("/api/v1/workspaces", app.openWorkspaceHandler)
-	app.r.GET("/api/v1/workspaces", app.listWorkspacesHandler)
-	app.r.DELETE("/api/v1/workspaces/:provider/:name", app.deleteWorkspaceHandler)
+	api.GET("/events", requireScope(ScopeWorkspacesRead), app.workspaceEventsHandler)
 
 	app.r.Static("/assets", "./frontend/dist/assets")
 	app.r.StaticFile("/", "./frontend/dist/index.html")
 	app.r.StaticFile("/favicon.ico", "./frontend/dist/favicon.ico")
 
-	if err := app.r.Run(config.ServerListenAddress()); err != nil {
+	var handler http.Handler = app.r
+
+	if config.DeploymentAuth() == config.DeploymentAuthProxy {
+		workspaceProxy := gin.New()
+		workspaceProxy.RedirectTrailingSlash = false
+		workspaceProxy.RedirectFixedPath = false
+
+		workspaceProxy.Use(gin.Logger(), gin.Recovery())
+		workspaceProxy.NoRoute(app.workspaceProxyHandler)
+
+		handler = hostRouter(app.r, workspaceProxy)
+	}
+
+	if err := http.ListenAndServe(config.ServerListenAddress(), handler); err != nil {
 		log.Fatalf("failed to start webserver: %v\n", err)
 	}
 }
@@ -29,6 +29,17 @@ type ServiceConfig struct {
 	Port uint16 `yaml:"port"`
 }
 
+type workspaceCheckout struct {
+	Ref          *models.ResolvedRef
+	Branch       string
+	Commit       string
+	MergeRequest models.MergeRequest
+}
+
+func (w *workspaceCheckout) isForkMergeRequest() bool {
+	return w.MergeRequest != nil && w.MergeRequest.IsFromFork()
+}
+
 type ProjectConfig struct {
 	CodeServer  *CodeServerConfig  `yaml:"codeServer"`
 	JbProjector *JbProjectorConfig `yaml:"jbProjector"`
@@ -37,7 +48,7 @@ type ProjectConfig struct {
 	Services []ServiceConfig `yaml:"services"`
 }
 
-func (p *ProjectConfig) createDeploymentSpec(project models.Project, user models.User, accessToken string) (*appsv1.DeploymentSpec, error) {
+func (p *ProjectConfig) createDeploymentSpec(project models.Project, user models.User, credentials *models.GitCredentials, checkout *workspaceCheckout) (*appsv1.DeploymentSpec, error) {
 	if p.CodeServer == nil && p.JbProjector == nil && p.JbFleet == nil {
 		p.CodeServer = &CodeServerConfig{}
 	}
@@ -58,9 +69,17 @@ func (p *ProjectConfig) createDeploymentSpec(project models.Project, user models
 				Name:  "REPO_URL",
 				Value: project.GetHttpCloneUrl(),
 			},
+			{
+				Name:  "REPO_BRANCH",
+				Value: checkout.Branch,
+			},
+			{
+				Name:  "REPO_COMMIT",
+				Value: checkout.Commit,
+			},
 			{
 				Name:  "GIT_HOST",
-				Value: parsedCloneUrl.Host,
+				Value: parsedCloneUrl.Hostname(),
 			},
 			{
 				Name:  "USERNAME",
@@ -70,10 +89,50 @@ func (p *ProjectConfig) createDeploymentSpec(project models.Project, user models
 				Name:  "EMAIL",
 				Value: user.GetEmail(),
 			},
+			{
+				Name:  "GIT_USERNAME",
+				Value: credentials.Username,
+			},
 			{
 				Name:  "ACCESS_TOKEN",
-				Value: accessToken,
+				Value: credentials.Password,
+			},
+			{
+				Name:  "SSH_PRIVATE_KEY",
+				Value: credentials.SshPrivateKey,
 			},
+			{
+				Name:  "SSH_KNOWN_HOSTS",
+				Value: credentials.SshKnownHosts,
+			},
+		}
+
+		cloneCommands := "git clone $REPO_URL /workspace\n"
+		if len(checkout.Branch) > 0 {
+			cloneCommands = "git clone --branch \"$REPO_BRANCH\" $REPO_URL /workspace\n"
+		}
+
+		cloneCommands += "cd /workspace\n"
+
+		if checkout.isForkMergeRequest() {
+			envVars = append(envVars,
+				corev1.EnvVar{
+					Name:  "MR_HEAD_REF",
+					Value: checkout.MergeRequest.GetHeadRef(),
+				},
+				corev1.EnvVar{
+					Name:  "MR_BRANCH",
+					Value: fmt.Sprintf("mr-%d", checkout.MergeRequest.GetNumber()),
+				},
+			)
+
+			cloneCommands += "git fetch origin \"$MR_HEAD_REF\"\n" +
+				"git checkout -b \"$MR_BRANCH\" FETCH_HEAD\n" +
+				"git branch --set-upstream-to=\"origin/$REPO_BRANCH\"\n"
+		} else if len(checkout.Branch) > 0 {
+			cloneCommands += "git reset --hard \"$REPO_COMMIT\"\n"
+		} else {
+			cloneCommands += "git checkout --detach \"$REPO_COMMIT\"\n"
 		}
 
 		codeServerImage := p.CodeServer.BaseImage
@@ -82,11 +141,29 @@ func (p *ProjectConfig) createDeploymentSpec(project models.Project, user models
 		}
 
 		workspaceSetupCommands := "set -v\n" +
-			"echo -e \"machine $GIT_HOST\\nlogin oauth2\\npassword $ACCESS_TOKEN\" > ~/.netrc\n" +
+			"mkdir -p /config/.ssh\n" +
+			"if [ -n \"$SSH_PRIVATE_KEY\" ]; then\n" +
+			"echo \"$SSH_PRIVATE_KEY\" > /config/.ssh/id_poddy\n" +
+			"chmod 600 /config/.ssh/id_poddy\n" +
+			// host keys are only verified with known hosts configured for
+			// the provider, otherwise they are accepted on first use
+			"SSH_HOST_KEY_CHECKING=accept-new\n" +
+			"if [ -n \"$SSH_KNOWN_HOSTS\" ]; then\n" +
+			"echo \"$SSH_KNOWN_HOSTS\" > /config/.ssh/known_hosts\n" +
+			"SSH_HOST_KEY_CHECKING=yes\n" +
+			"fi\n" +
+			"export GIT_SSH_COMMAND=\"ssh -i /config/.ssh/id_poddy -o IdentitiesOnly=yes -o StrictHostKeyChecking=$SSH_HOST_KEY_CHECKING -o UserKnownHostsFile=/config/.ssh/known_hosts\"\n" +
+			"touch ~/.netrc\n" +
+			"else\n" +
+			"echo -e \"machine $GIT_HOST\\nlogin $GIT_USERNAME\\npassword $ACCESS_TOKEN\" > ~/.netrc\n" +
+			"fi\n" +
 			"chmod 600 ~/.netrc\n" +
-			"git clone $REPO_URL /workspace\n" +
+			cloneCommands +
 			"cp ~/.netrc /config/.netrc\n" +
-			"echo -e \"[user]\\\\n        name = $USERNAME\\\\n        email = $EMAIL\" > /config/.gitconfig\n"
+			"echo -e \"[user]\\\\n        name = $USERNAME\\\\n        email = $EMAIL\" > /config/.gitconfig\n" +
+			"if [ -n \"$SSH_PRIVATE_KEY\" ]; then\n" +
+			"echo -e \"[core]\\\\n        sshCommand = ssh -i /home/coder/.ssh/id_poddy -o IdentitiesOnly=yes -o StrictHostKeyChecking=$SSH_HOST_KEY_CHECKING -o UserKnownHostsFile=/home/coder/.ssh/known_hosts\" >> /config/.gitconfig\n" +
+			"fi\n"
 
 		extensionCommands := ""
 		for _, extension := range p.CodeServer.Extensions {
@@ -167,6 +244,11 @@ func (p *ProjectConfig) createDeploymentSpec(project models.Project, user models
 									MountPath: "/home/coder/.gitconfig",
 									SubPath:   ".gitconfig",
 								},
+								{
+									Name:      "config-data",
+									MountPath: "/home/coder/.ssh",
+									SubPath:   ".ssh",
+								},
 							},
 						},
 					},
@@ -257,3 +339,17 @@ func (p *ProjectConfig) getIngressRules(workspaceName, ingressDomain string) []n
 
 	return ingressRules
 }
+
+// getServiceUrls returns the urls the workspace server and the services are
+// exposed at, keyed by their port names.
+func (p *ProjectConfig) getServiceUrls(ingressDomain string) map[string]string {
+	serviceUrls := map[string]string{
+		"server": workspaceUrl(ingressDomain),
+	}
+
+	for i := 0; i < len(p.Services); i++ {
+		serviceUrls[p.Services[i].Name] = workspaceUrl(fmt.Sprintf("%s-%s", p.Services[i].Name, ingressDomain))
+	}
+
+	return serviceUrls
+}
@@ -0,0 +1,245 @@
+package poddy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dogboy21/poddy/config"
+	"github.com/dogboy21/poddy/sessionstore"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-contrib/sessions/cookie"
+	"github.com/gin-gonic/gin"
+	"k8s.io/client-go/kubernetes"
+)
+
+const sessionPurgeInterval = time.Hour
+
+// newSessionStore creates the session store configured by sessions.store. The
+// server-side store is nil for the cookie store, as cookie sessions can
+// neither be listed nor revoked.
+func newSessionStore(keyPairs [][]byte) (sessions.Store, *sessionstore.Store, error) {
+	switch config.SessionsStore() {
+	case "cookie":
+		return cookie.NewStore(keyPairs...), nil, nil
+	case "file":
+		backend, err := sessionstore.NewBoltBackend(config.SessionsFilePath())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		store := sessionstore.NewStore(backend, keyPairs...)
+		return store, store, nil
+	case "kubernetes":
+		kubernetesConfig, err := getKubernetesConfig()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get Kubernetes config: %w", err)
+		}
+
+		clientSet, err := kubernetes.NewForConfig(kubernetesConfig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+		}
+
+		store := sessionstore.NewStore(sessionstore.NewKubernetesBackend(clientSet, config.SessionsNamespace()), keyPairs...)
+		return store, store, nil
+	}
+
+	return nil, nil, fmt.Errorf("unknown session store: %s (supported stores: cookie, file, kubernetes)", config.SessionsStore())
+}
+
+func sessionIdentities(values map[interface{}]interface{}, providerConfigs []config.OauthRepositoryProviderConfig) map[string]string {
+	identities := make(map[string]string)
+
+	for _, providerConfig := range providerConfigs {
+		if username, ok := values[sessionUserKey(&providerConfig)].(string); ok {
+			identities[providerConfig.ID] = username
+		}
+	}
+
+	return identities
+}
+
+// sessionUserIds returns the ids of the provider users the session is logged
+// in as. Unlike usernames they can't be renamed and handed on to someone else.
+func sessionUserIds(values map[interface{}]interface{}, providerConfigs []config.OauthRepositoryProviderConfig) map[string]string {
+	userIds := make(map[string]string)
+
+	for _, providerConfig := range providerConfigs {
+		if userId, ok := values[sessionUserIdKey(&providerConfig)].(string); ok && len(userId) > 0 {
+			userIds[providerConfig.ID] = userId
+		}
+	}
+
+	return userIds
+}
+
+func poddyUserOwner(user *poddyUser) string {
+	return "user|" + user.ID()
+}
+
+func providerUserOwner(providerId, userId string) string {
+	return "provider|" + providerId + "|" + userId
+}
+
+// sessionOwners tells the session store who a session or api token belongs
+// to: its poddy user and the provider users it is logged in as.
+func (p *poddy) sessionOwners(values map[interface{}]interface{}) []string {
+	owners := make([]string, 0)
+
+	if user, _ := decodePoddyUser(values[sessionPoddyUserKey]); user != nil {
+		owners = append(owners, poddyUserOwner(user))
+	}
+
+	for providerId, userId := range sessionUserIds(values, p.oauthRepositoryProviderConfigs) {
+		owners = append(owners, providerUserOwner(providerId, userId))
+	}
+
+	return owners
+}
+
+// userSessions returns the sessions or api tokens, depending on the kind, of
+// the poddy user or, without an OIDC login, those that are logged in as the
+// same user on any of the providers the current session is logged in to.
+func (p *poddy) userSessions(c *gin.Context, kind string) ([]sessionstore.SessionInfo, string, error) {
+	session := sessions.Default(c)
+	currentHandle := ""
+	if len(session.ID()) > 0 {
+		currentHandle = sessionstore.HandleForId(session.ID())
+	}
+
+	currentUser, err := ReadPoddyUserFromSession(session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	currentUserIds := make(map[string]string)
+	for _, providerConfig := range p.oauthRepositoryProviderConfigs {
+		if userId := ReadUserIdFromSession(session, &providerConfig); len(userId) > 0 {
+			currentUserIds[providerConfig.ID] = userId
+		}
+	}
+
+	if currentUser == nil && len(currentUserIds) == 0 {
+		return nil, "", nil
+	}
+
+	owners := make([]string, 0, len(currentUserIds))
+	if currentUser != nil {
+		owners = append(owners, poddyUserOwner(currentUser))
+	} else {
+		for providerId, userId := range currentUserIds {
+			owners = append(owners, providerUserOwner(providerId, userId))
+		}
+	}
+
+	sessionInfos, err := p.sessionStore.ListSessions(c.Request.Context(), owners)
+	if err != nil {
+		return nil, "", err
+	}
+
+	belongsToUser := func(sessionInfo sessionstore.SessionInfo) bool {
+		if currentUser != nil {
+			user, _ := decodePoddyUser(sessionInfo.Values[sessionPoddyUserKey])
+			return user != nil && user.ID() == currentUser.ID()
+		}
+
+		for providerId, userId := range sessionUserIds(sessionInfo.Values, p.oauthRepositoryProviderConfigs) {
+			if currentUserIds[providerId] == userId {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	userSessions := make([]sessionstore.SessionInfo, 0)
+	for _, sessionInfo := range sessionInfos {
+		if sessionInfo.Kind == kind && belongsToUser(sessionInfo) {
+			userSessions = append(userSessions, sessionInfo)
+		}
+	}
+
+	sortSessions(userSessions)
+
+	return userSessions, currentHandle, nil
+}
+
+func sortSessions(sessionInfos []sessionstore.SessionInfo) {
+	sort.Slice(sessionInfos, func(i, j int) bool {
+		return sessionInfos[i].UpdatedAt.After(sessionInfos[j].UpdatedAt)
+	})
+}
+
+func (p *poddy) listSessionsHandler(c *gin.Context) {
+	if p.sessionStore == nil {
+		c.AbortWithStatus(http.StatusNotImplemented)
+		return
+	}
+
+	userSessions, currentHandle, err := p.userSessions(c, sessionstore.KindSession)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to list sessions: %w", err))
+		return
+	}
+
+	if userSessions == nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	items := make([]map[string]interface{}, len(userSessions))
+	for i, sessionInfo := range userSessions {
+		items[i] = map[string]interface{}{
+			"id":         sessionInfo.Handle,
+			"current":    sessionInfo.Handle == currentHandle,
+			"user_agent": sessionInfo.UserAgent,
+			"created_at": sessionInfo.CreatedAt,
+			"last_seen":  sessionInfo.UpdatedAt,
+			"expires_at": sessionInfo.ExpiresAt,
+			"providers":  sessionIdentities(sessionInfo.Values, p.oauthRepositoryProviderConfigs),
+		}
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+func (p *poddy) revokeSessionHandler(c *gin.Context) {
+	if p.sessionStore == nil {
+		c.AbortWithStatus(http.StatusNotImplemented)
+		return
+	}
+
+	userSessions, _, err := p.userSessions(c, sessionstore.KindSession)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to list sessions: %w", err))
+		return
+	}
+
+	if userSessions == nil {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	for _, sessionInfo := range userSessions {
+		if sessionInfo.Handle == c.Param("id") {
+			if err := p.sessionStore.RevokeSession(c.Request.Context(), sessionInfo); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to revoke session: %w", err))
+				return
+			}
+
+			c.Status(http.StatusNoContent)
+			return
+		}
+	}
+
+	c.AbortWithStatus(http.StatusNotFound)
+}
+
+func (p *poddy) purgeExpiredSessions() {
+	if p.sessionStore != nil {
+		p.sessionStore.RunPurge(context.Background(), sessionPurgeInterval)
+	}
+}
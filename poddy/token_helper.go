@@ -3,45 +3,466 @@ package poddy
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
+	"time"
 
 	"github.com/dogboy21/poddy/config"
+	"github.com/dogboy21/poddy/models"
+	"github.com/dogboy21/poddy/sessionstore"
 	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
 	"golang.org/x/oauth2"
 )
 
+// sharedTokens holds the provider tokens that a session shares with the api
+// tokens minted from it, so a refreshed token, and with GitLab the rotated
+// refresh token, is seen by all of them. Cookie sessions can't mint api
+// tokens and keep their provider tokens to themselves.
+var sharedTokens *sessionstore.Store
+
+func sessionTokenKey(providerConfig *config.OauthRepositoryProviderConfig) string {
+	return fmt.Sprintf("%s_token", providerConfig.ID)
+}
+
+func sessionTokenRefKey(providerConfig *config.OauthRepositoryProviderConfig) string {
+	return fmt.Sprintf("%s_token_ref", providerConfig.ID)
+}
+
+// SaveTokenToSession stores the token of a new login in the session itself,
+// which leaves any token shared with api tokens of an earlier login to them.
 func SaveTokenToSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig, source oauth2.TokenSource) error {
 	token, err := source.Token()
 	if err != nil {
 		return err
 	}
 
+	session.Delete(sessionTokenRefKey(providerConfig))
+
+	return saveTokenToSession(session, providerConfig, token)
+}
+
+func saveTokenToSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig, token *oauth2.Token) error {
 	jsonToken, err := json.Marshal(token)
 	if err != nil {
 		return err
 	}
 
-	session.Set(fmt.Sprintf("%s_token", providerConfig.ID), string(jsonToken))
+	if ref, ok := session.Get(sessionTokenRefKey(providerConfig)).(string); ok && sharedTokens != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), config.TimeoutsKubernetesRequest())
+		defer cancel()
+
+		return sharedTokens.SaveSharedValue(ctx, ref, string(jsonToken), time.Time{})
+	}
+
+	session.Set(sessionTokenKey(providerConfig), string(jsonToken))
 
 	return nil
 }
 
+// loadSessionToken returns the encoded token of the session, which is either
+// stored in the session or shared with api tokens.
+func loadSessionToken(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig) (string, error) {
+	if ref, ok := session.Get(sessionTokenRefKey(providerConfig)).(string); ok && sharedTokens != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), config.TimeoutsKubernetesRequest())
+		defer cancel()
+
+		return sharedTokens.LoadSharedValue(ctx, ref)
+	}
+
+	jsonToken, _ := session.Get(sessionTokenKey(providerConfig)).(string)
+	return jsonToken, nil
+}
+
+// shareSessionToken moves the token of the session to a shared value that
+// lives at least until the given time and returns its id, or an empty id if
+// the session has no token.
+func shareSessionToken(ctx context.Context, session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig, expiresAt time.Time) (string, error) {
+	jsonToken, err := loadSessionToken(session, providerConfig)
+	if err != nil || len(jsonToken) == 0 {
+		return "", err
+	}
+
+	ref, ok := session.Get(sessionTokenRefKey(providerConfig)).(string)
+	if !ok {
+		ref = sessionstore.NewSharedValueId()
+	}
+
+	if err := sharedTokens.SaveSharedValue(ctx, ref, jsonToken, expiresAt); err != nil {
+		return "", err
+	}
+
+	session.Set(sessionTokenRefKey(providerConfig), ref)
+	session.Delete(sessionTokenKey(providerConfig))
+
+	return ref, nil
+}
+
+// ReadTokenFromSession returns a token source for the token stored in the
+// session. Refreshed tokens are written back to the session, as providers like
+// GitLab rotate the refresh token on every refresh.
 func ReadTokenFromSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig) (oauth2.TokenSource, error) {
-	sessionValue := session.Get(fmt.Sprintf("%s_token", providerConfig.ID))
-	if sessionValue == nil {
-		return nil, nil
+	jsonToken, err := loadSessionToken(session, providerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load token: %w", err)
 	}
 
-	jsonToken := sessionValue.(string)
+	if len(jsonToken) == 0 {
+		return nil, nil
+	}
 
 	token := oauth2.Token{}
 	if err := json.Unmarshal([]byte(jsonToken), &token); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal token: %v", err)
 	}
 
-	return providerConfig.OauthConfig.TokenSource(context.Background(), &token), nil
+	// personal access tokens can't be refreshed
+	if providerConfig.OauthConfig == nil || len(token.RefreshToken) == 0 {
+		return oauth2.StaticTokenSource(&token), nil
+	}
+
+	return &sessionTokenSource{
+		session:        session,
+		providerConfig: providerConfig,
+		token:          &token,
+	}, nil
+}
+
+type sessionTokenSource struct {
+	session        sessions.Session
+	providerConfig *config.OauthRepositoryProviderConfig
+
+	mutex sync.Mutex
+	token *oauth2.Token
+}
+
+// tokenRefreshLocks serializes the refreshes of a token across all requests
+// using it. GitLab rotates the refresh token, so of two concurrent refreshes
+// the second one would fail and log the user out.
+var tokenRefreshLocks = keyedMutex{locks: make(map[string]*keyedLock)}
+
+type keyedMutex struct {
+	mutex sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	users int
+}
+
+// Lock locks the key and returns the function unlocking it again. Keys are
+// forgotten once nobody holds or waits for them.
+func (k *keyedMutex) Lock(key string) func() {
+	k.mutex.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.users++
+	k.mutex.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		k.mutex.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(k.locks, key)
+		}
+		k.mutex.Unlock()
+	}
+}
+
+// refreshLockKey returns the key the refreshes of the token are serialized
+// by. A token shared with api tokens is refreshed by all of them, cookie
+// sessions have no id and are only told apart by their refresh token.
+func (s *sessionTokenSource) refreshLockKey() string {
+	if ref, ok := s.session.Get(sessionTokenRefKey(s.providerConfig)).(string); ok {
+		return "ref|" + ref
+	}
+
+	if len(s.session.ID()) > 0 {
+		return "session|" + s.session.ID() + "|" + s.providerConfig.ID
+	}
+
+	return "token|" + s.providerConfig.ID + "|" + s.token.RefreshToken
+}
+
+// storedToken returns the token as it is currently stored, which another
+// request may have refreshed since this one read it, or nil if it can't be
+// reloaded, like with cookie sessions.
+func (s *sessionTokenSource) storedToken() *oauth2.Token {
+	var jsonToken string
+
+	if _, ok := s.session.Get(sessionTokenRefKey(s.providerConfig)).(string); ok {
+		var err error
+		jsonToken, err = loadSessionToken(s.session, s.providerConfig)
+		if err != nil {
+			log.Printf("failed to reload token for %s: %v\n", s.providerConfig.ID, err)
+			return nil
+		}
+	} else if sharedTokens != nil && len(s.session.ID()) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), config.TimeoutsKubernetesRequest())
+		defer cancel()
+
+		values, err := sharedTokens.LoadValues(ctx, s.session.ID())
+		if err != nil {
+			log.Printf("failed to reload token for %s: %v\n", s.providerConfig.ID, err)
+			return nil
+		}
+
+		jsonToken, _ = values[sessionTokenKey(s.providerConfig)].(string)
+	}
+
+	if len(jsonToken) == 0 {
+		return nil
+	}
+
+	token := oauth2.Token{}
+	if err := json.Unmarshal([]byte(jsonToken), &token); err != nil {
+		return nil
+	}
+
+	if token.AccessToken == s.token.AccessToken && token.RefreshToken == s.token.RefreshToken {
+		return nil
+	}
+
+	// keep the session from writing back the token it started with
+	if _, ok := s.session.Get(sessionTokenRefKey(s.providerConfig)).(string); !ok {
+		s.session.Set(sessionTokenKey(s.providerConfig), jsonToken)
+	}
+
+	return &token
+}
+
+func (s *sessionTokenSource) refresh() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.providerConfig.RequestTimeout)
+	defer cancel()
+
+	token, err := s.providerConfig.OauthConfig.TokenSource(ctx, s.token).Token()
+	if err != nil {
+		return nil, models.NewTransportError(fmt.Errorf("failed to refresh token: %w", err))
+	}
+
+	return token, nil
+}
+
+// Token refreshes the token once it expires, within the request timeout of
+// the provider as the token endpoint would otherwise be waited for forever.
+// A token another request refreshed in the meantime is used instead.
+func (s *sessionTokenSource) Token() (*oauth2.Token, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	unlock := tokenRefreshLocks.Lock(s.refreshLockKey())
+	defer unlock()
+
+	if s.token.Valid() {
+		return s.token, nil
+	}
+
+	if storedToken := s.storedToken(); storedToken != nil {
+		s.token = storedToken
+		if s.token.Valid() {
+			return s.token, nil
+		}
+	}
+
+	token, err := s.refresh()
+
+	// another poddy instance may have rotated the refresh token meanwhile
+	if errors.Is(err, models.ErrUnauthorized) {
+		if storedToken := s.storedToken(); storedToken != nil {
+			s.token = storedToken
+			if s.token.Valid() {
+				return s.token, nil
+			}
+
+			token, err = s.refresh()
+		}
+	}
+
+	if err != nil {
+		// the refresh token is dead, drop it so the user is asked to log in again
+		if errors.Is(err, models.ErrUnauthorized) {
+			RemoveTokenFromSession(s.session, s.providerConfig)
+			RemoveUserFromSession(s.session, s.providerConfig)
+			if err := s.session.Save(); err != nil {
+				log.Printf("failed to save session after removing token for %s: %v\n", s.providerConfig.ID, err)
+			}
+		}
+
+		return nil, err
+	}
+
+	if token.AccessToken != s.token.AccessToken {
+		if err := saveTokenToSession(s.session, s.providerConfig, token); err != nil {
+			log.Printf("failed to save refreshed token for %s: %v\n", s.providerConfig.ID, err)
+		} else if err := s.session.Save(); err != nil {
+			log.Printf("failed to save session with refreshed token for %s: %v\n", s.providerConfig.ID, err)
+		}
+	}
+
+	s.token = token
+
+	return token, nil
 }
 
 func RemoveTokenFromSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig) {
-	session.Delete(fmt.Sprintf("%s_token", providerConfig.ID))
+	session.Delete(sessionTokenKey(providerConfig))
+	session.Delete(sessionTokenRefKey(providerConfig))
+}
+
+// credentialsCodecs encrypt the stored git credentials with the cookie keys,
+// so neither the session store nor anyone reading it learns the password or
+// ssh key.
+var credentialsCodecs []securecookie.Codec
+
+func setCredentialsKeys(keyPairs [][]byte) {
+	credentialsCodecs = securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range credentialsCodecs {
+		// the session expires the credentials along with itself
+		codec.(*securecookie.SecureCookie).MaxAge(0)
+	}
+}
+
+func credentialsSessionKey(providerConfig *config.OauthRepositoryProviderConfig) string {
+	return fmt.Sprintf("%s_credentials", providerConfig.ID)
+}
+
+func SaveCredentialsToSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig, credentials *models.UserCredentials) error {
+	encryptedCredentials, err := securecookie.EncodeMulti(credentialsSessionKey(providerConfig), credentials, credentialsCodecs...)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt credentials: %w", err)
+	}
+
+	session.Set(credentialsSessionKey(providerConfig), encryptedCredentials)
+
+	return nil
+}
+
+// ReadCredentialsFromSession returns nil for credentials that can't be
+// decrypted, like those stored in plain text by earlier versions, so the user
+// logs in again.
+func ReadCredentialsFromSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig) (*models.UserCredentials, error) {
+	encryptedCredentials, ok := session.Get(credentialsSessionKey(providerConfig)).(string)
+	if !ok {
+		return nil, nil
+	}
+
+	credentials := models.UserCredentials{}
+	if err := securecookie.DecodeMulti(credentialsSessionKey(providerConfig), encryptedCredentials, &credentials, credentialsCodecs...); err != nil {
+		log.Printf("dropping undecryptable credentials for %s: %v\n", providerConfig.ID, err)
+		return nil, nil
+	}
+
+	return &credentials, nil
+}
+
+func RemoveCredentialsFromSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig) {
+	session.Delete(credentialsSessionKey(providerConfig))
+}
+
+func sessionUserKey(providerConfig *config.OauthRepositoryProviderConfig) string {
+	return fmt.Sprintf("%s_user", providerConfig.ID)
+}
+
+func sessionUserIdKey(providerConfig *config.OauthRepositoryProviderConfig) string {
+	return fmt.Sprintf("%s_user_id", providerConfig.ID)
+}
+
+// SaveUserToSession remembers who the session is logged in as, which is used
+// to find all sessions of a user and to authorize workspace requests without
+// asking the provider.
+func SaveUserToSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig, userId, username string) {
+	session.Set(sessionUserIdKey(providerConfig), userId)
+	session.Set(sessionUserKey(providerConfig), username)
+}
+
+func ReadUserFromSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig) string {
+	username, _ := session.Get(sessionUserKey(providerConfig)).(string)
+	return username
+}
+
+func ReadUserIdFromSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig) string {
+	userId, _ := session.Get(sessionUserIdKey(providerConfig)).(string)
+	return userId
+}
+
+func RemoveUserFromSession(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig) {
+	session.Delete(sessionUserIdKey(providerConfig))
+	session.Delete(sessionUserKey(providerConfig))
+}
+
+// getSessionRepositoryProvider returns the repository provider for the given
+// config that is authenticated with whatever the session holds for it, be it
+// an OAuth token or stored git credentials. A nil provider is returned if the
+// session isn't logged in to the provider.
+func getSessionRepositoryProvider(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig) (models.RepositoryProvider, error) {
+	if providerConfig.IsCredentialsProvider() {
+		credentials, err := ReadCredentialsFromSession(session, providerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials from session: %v", err)
+		}
+
+		if credentials == nil {
+			return nil, nil
+		}
+
+		return providerConfig.GetCredentialsRepositoryProvider(credentials)
+	}
+
+	tokenSource, err := ReadTokenFromSession(session, providerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token from session: %v", err)
+	}
+
+	if tokenSource == nil {
+		return nil, nil
+	}
+
+	return providerConfig.GetRepositoryProvider(tokenSource)
+}
+
+func getSessionGitCredentials(session sessions.Session, providerConfig *config.OauthRepositoryProviderConfig) (*models.GitCredentials, error) {
+	if providerConfig.IsCredentialsProvider() {
+		credentials, err := ReadCredentialsFromSession(session, providerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials from session: %v", err)
+		}
+
+		if credentials == nil {
+			return nil, errors.New("no credentials stored in session")
+		}
+
+		gitCredentials := credentials.GetGitCredentials()
+		gitCredentials.SshKnownHosts = providerConfig.SshKnownHosts
+
+		return gitCredentials, nil
+	}
+
+	tokenSource, err := ReadTokenFromSession(session, providerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token from session: %v", err)
+	}
+
+	if tokenSource == nil {
+		return nil, errors.New("no token stored in session")
+	}
+
+	token, err := tokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	return &models.GitCredentials{
+		Username: "oauth2",
+		Password: token.AccessToken,
+	}, nil
 }
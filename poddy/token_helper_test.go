@@ -0,0 +1,202 @@
+package poddy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dogboy21/poddy/config"
+	"github.com/dogboy21/poddy/sessionstore"
+	"golang.org/x/oauth2"
+)
+
+// newRotatingTokenServer returns a token endpoint that rotates the refresh
+// token like GitLab does and rejects refresh tokens it already rotated.
+func newRotatingTokenServer(t *testing.T) (*httptest.Server, *int) {
+	var mutex sync.Mutex
+	refreshes := 0
+	currentRefreshToken := "refresh-0"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.FormValue("refresh_token") != currentRefreshToken {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant"}`)
+			return
+		}
+
+		// leave concurrent refreshes time to overlap
+		time.Sleep(10 * time.Millisecond)
+
+		refreshes++
+		currentRefreshToken = fmt.Sprintf("refresh-%d", refreshes)
+		fmt.Fprintf(w, `{"access_token":"access-%d","refresh_token":"%s","token_type":"bearer","expires_in":3600}`, refreshes, currentRefreshToken)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &refreshes
+}
+
+func newTestTokenStore(t *testing.T) *sessionstore.Store {
+	backend, err := sessionstore.NewBoltBackend(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("failed to create backend: %v", err)
+	}
+
+	previous := sharedTokens
+	sharedTokens = sessionstore.NewStore(backend, []byte("0123456789abcdef0123456789abcdef"))
+	t.Cleanup(func() {
+		sharedTokens = previous
+	})
+
+	return sharedTokens
+}
+
+func TestSessionTokenSourceConcurrentRefresh(t *testing.T) {
+	server, refreshes := newRotatingTokenServer(t)
+	store := newTestTokenStore(t)
+	ctx := context.Background()
+
+	providerConfig := &config.OauthRepositoryProviderConfig{
+		ID:             "gitlab",
+		RequestTimeout: 5 * time.Second,
+		OauthConfig: &oauth2.Config{
+			Endpoint: oauth2.Endpoint{TokenURL: server.URL},
+		},
+	}
+
+	expiredToken, err := json.Marshal(&oauth2.Token{
+		AccessToken:  "access-0",
+		RefreshToken: "refresh-0",
+		Expiry:       time.Now().Add(-time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal token: %v", err)
+	}
+
+	ref := sessionstore.NewSharedValueId()
+	if err := store.SaveSharedValue(ctx, ref, string(expiredToken), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to save shared token: %v", err)
+	}
+
+	apiToken, err := store.CreateApiToken(ctx, "test", nil, map[interface{}]interface{}{
+		sessionTokenRefKey(providerConfig): ref,
+	}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create api token: %v", err)
+	}
+
+	// every request loads its own session and token source
+	const requests = 5
+	sources := make([]oauth2.TokenSource, requests)
+	for i := range sources {
+		session, err := store.LoadApiToken(ctx, apiToken)
+		if err != nil || session == nil {
+			t.Fatalf("failed to load api token: %v", err)
+		}
+
+		sources[i], err = ReadTokenFromSession(session, providerConfig)
+		if err != nil {
+			t.Fatalf("failed to read token: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	tokens := make([]*oauth2.Token, requests)
+	errs := make([]error, requests)
+	for i := range sources {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], errs[i] = sources[i].Token()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range sources {
+		if errs[i] != nil {
+			t.Fatalf("request %d failed to get token: %v", i, errs[i])
+		}
+
+		if tokens[i].AccessToken != "access-1" {
+			t.Errorf("request %d got access token %s, want access-1", i, tokens[i].AccessToken)
+		}
+	}
+
+	if *refreshes != 1 {
+		t.Errorf("token was refreshed %d times, want once", *refreshes)
+	}
+}
+
+func TestSessionTokenSourceReloadsStoredToken(t *testing.T) {
+	server, refreshes := newRotatingTokenServer(t)
+	store := newTestTokenStore(t)
+	ctx := context.Background()
+
+	providerConfig := &config.OauthRepositoryProviderConfig{
+		ID:             "gitlab",
+		RequestTimeout: 5 * time.Second,
+		OauthConfig: &oauth2.Config{
+			Endpoint: oauth2.Endpoint{TokenURL: server.URL},
+		},
+	}
+
+	apiToken, err := store.CreateApiToken(ctx, "test", nil, map[interface{}]interface{}{
+		sessionTokenKey(providerConfig): `{"access_token":"access-0","refresh_token":"refresh-0","expiry":"2000-01-01T00:00:00Z"}`,
+	}, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("failed to create api token: %v", err)
+	}
+
+	staleSession, err := store.LoadApiToken(ctx, apiToken)
+	if err != nil || staleSession == nil {
+		t.Fatalf("failed to load api token: %v", err)
+	}
+
+	staleSource, err := ReadTokenFromSession(staleSession, providerConfig)
+	if err != nil {
+		t.Fatalf("failed to read token: %v", err)
+	}
+
+	// another request refreshes the token after the stale one was read
+	session, err := store.LoadApiToken(ctx, apiToken)
+	if err != nil || session == nil {
+		t.Fatalf("failed to load api token: %v", err)
+	}
+
+	source, err := ReadTokenFromSession(session, providerConfig)
+	if err != nil {
+		t.Fatalf("failed to read token: %v", err)
+	}
+
+	if _, err := source.Token(); err != nil {
+		t.Fatalf("failed to refresh token: %v", err)
+	}
+
+	token, err := staleSource.Token()
+	if err != nil {
+		t.Fatalf("stale request failed to get token: %v", err)
+	}
+
+	if token.AccessToken != "access-1" {
+		t.Errorf("stale request got access token %s, want access-1", token.AccessToken)
+	}
+
+	if *refreshes != 1 {
+		t.Errorf("token was refreshed %d times, want once", *refreshes)
+	}
+
+	if _, ok := staleSession.Get(sessionTokenKey(providerConfig)).(string); !ok {
+		t.Errorf("stale session lost its token")
+	}
+}
@@ -0,0 +1,430 @@
+package poddy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/dogboy21/poddy/config"
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+type workspaceEventType string
+
+const (
+	workspaceEventCreated workspaceEventType = "created"
+	workspaceEventUpdated workspaceEventType = "updated"
+	workspaceEventDeleted workspaceEventType = "deleted"
+)
+
+// how many events may queue up for a subscriber before it is dropped
+const workspaceEventBuffer = 64
+
+type workspaceEvent struct {
+	Type      workspaceEventType `json:"type"`
+	Workspace workspaceInfo      `json:"workspace"`
+
+	// the owner labels of the workspace the subscribers are filtered by
+	labels map[string]string
+}
+
+// the index of the cached warning events by the uid of their pod
+const eventsByPodIndex = "pod"
+
+// watchedWorkspace is the last state of a workspace passed on to the
+// subscribers.
+type watchedWorkspace struct {
+	workspace workspaceInfo
+	labels    map[string]string
+}
+
+// workspaceWatcher passes changes of the poddy-managed deployments and pods on
+// to the subscribers as workspace events. All subscribers share a single set
+// of informers, which is started with the first subscription. The informer
+// handlers only queue the names of the changed workspaces, their status is
+// derived from the caches by a worker, so slow subscribers or status lookups
+// don't hold up the informers.
+type workspaceWatcher struct {
+	startOnce sync.Once
+	startErr  error
+	synced    []cache.InformerSynced
+
+	deployments appslisters.DeploymentLister
+	pods        corelisters.PodLister
+	events      cache.Indexer
+	queue       workqueue.Interface
+
+	mutex       sync.Mutex
+	subscribers map[chan workspaceEvent]struct{}
+	// the last state of every workspace, which keeps pod updates that don't
+	// change the workspace from being passed on
+	workspaces map[string]watchedWorkspace
+}
+
+func (w *workspaceWatcher) start() error {
+	w.startOnce.Do(func() {
+		kubernetesConfig, err := getKubernetesConfig()
+		if err != nil {
+			w.startErr = fmt.Errorf("failed to get Kubernetes config: %w", err)
+			return
+		}
+
+		// the request timeout would end the watches of the informers
+		kubernetesConfig = rest.CopyConfig(kubernetesConfig)
+		kubernetesConfig.Timeout = 0
+
+		clientSet, err := kubernetes.NewForConfig(kubernetesConfig)
+		if err != nil {
+			w.startErr = fmt.Errorf("failed to create Kubernetes client: %w", err)
+			return
+		}
+
+		factory := informers.NewSharedInformerFactoryWithOptions(clientSet, 0,
+			informers.WithNamespace(config.DeploymentNamespace()),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.LabelSelector = "managed-by=poddy"
+			}),
+		)
+
+		// events don't carry the labels of the object they are about
+		eventFactory := informers.NewSharedInformerFactoryWithOptions(clientSet, 0,
+			informers.WithNamespace(config.DeploymentNamespace()),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.Set{
+					"involvedObject.kind": "Pod",
+					"type":                corev1.EventTypeWarning,
+				}.String()
+			}),
+		)
+
+		deploymentInformer := factory.Apps().V1().Deployments()
+		podInformer := factory.Core().V1().Pods()
+		eventInformer := eventFactory.Core().V1().Events()
+
+		err = eventInformer.Informer().AddIndexers(cache.Indexers{
+			eventsByPodIndex: func(obj interface{}) ([]string, error) {
+				event, ok := obj.(*corev1.Event)
+				if !ok {
+					return nil, nil
+				}
+
+				return []string{string(event.InvolvedObject.UID)}, nil
+			},
+		})
+		if err != nil {
+			w.startErr = fmt.Errorf("failed to index events: %w", err)
+			return
+		}
+
+		w.deployments = deploymentInformer.Lister()
+		w.pods = podInformer.Lister()
+		w.events = eventInformer.Informer().GetIndexer()
+		w.queue = workqueue.New()
+		w.workspaces = make(map[string]watchedWorkspace)
+
+		deploymentInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				w.enqueueObject(obj)
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				w.enqueueObject(obj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				w.enqueueObject(obj)
+			},
+		})
+
+		podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				w.enqueueObject(obj)
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				w.enqueueObject(obj)
+			},
+			DeleteFunc: func(obj interface{}) {
+				w.enqueueObject(obj)
+			},
+		})
+
+		eventInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				w.enqueueEvent(obj)
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				w.enqueueEvent(obj)
+			},
+		})
+
+		w.synced = []cache.InformerSynced{
+			deploymentInformer.Informer().HasSynced,
+			podInformer.Informer().HasSynced,
+			eventInformer.Informer().HasSynced,
+		}
+
+		factory.Start(nil)
+		eventFactory.Start(nil)
+
+		go w.run()
+	})
+
+	return w.startErr
+}
+
+// enqueueObject queues the workspace a deployment or pod belongs to.
+func (w *workspaceWatcher) enqueueObject(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	object, ok := obj.(metav1.Object)
+	if !ok {
+		return
+	}
+
+	if workspaceName := object.GetLabels()["workspace-name"]; len(workspaceName) > 0 {
+		w.queue.Add(workspaceName)
+	}
+}
+
+// enqueueEvent queues the workspace whose pod the event is about.
+func (w *workspaceWatcher) enqueueEvent(obj interface{}) {
+	event, ok := obj.(*corev1.Event)
+	if !ok {
+		return
+	}
+
+	pod, err := w.pods.Pods(config.DeploymentNamespace()).Get(event.InvolvedObject.Name)
+	if err != nil {
+		// not a pod of a workspace or already gone
+		return
+	}
+
+	w.enqueueObject(pod)
+}
+
+// run passes the queued workspaces on until the queue is shut down.
+func (w *workspaceWatcher) run() {
+	for {
+		item, shutdown := w.queue.Get()
+		if shutdown {
+			return
+		}
+
+		w.sync(item.(string))
+		w.queue.Done(item)
+	}
+}
+
+// warningEvents returns the cached warning events of the pod.
+func (w *workspaceWatcher) warningEvents(pod *corev1.Pod) ([]corev1.Event, error) {
+	objects, err := w.events.ByIndex(eventsByPodIndex, string(pod.UID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached events: %w", err)
+	}
+
+	events := make([]corev1.Event, 0, len(objects))
+	for _, obj := range objects {
+		if event, ok := obj.(*corev1.Event); ok {
+			events = append(events, *event)
+		}
+	}
+
+	return events, nil
+}
+
+// workspaceInfo returns the workspace of the deployment along with the status
+// derived from the cached pods and events.
+func (w *workspaceWatcher) workspaceInfo(deployment *appsv1.Deployment) workspaceInfo {
+	workspace := workspaceInfoFromDeployment(deployment)
+
+	cachedPods, err := w.pods.Pods(config.DeploymentNamespace()).List(labels.SelectorFromSet(labels.Set{
+		"workspace-name": workspace.Name,
+	}))
+	if err != nil {
+		log.Printf("failed to list cached pods of workspace %s: %v\n", workspace.Name, err)
+	}
+
+	pods := make([]corev1.Pod, len(cachedPods))
+	for i, pod := range cachedPods {
+		pods[i] = *pod
+	}
+
+	workspace.Status, workspace.StatusReason = explainWorkspaceStatus(deployment, pods, w.warningEvents)
+
+	return workspace
+}
+
+// sync passes the current state of the workspace on to the subscribers.
+func (w *workspaceWatcher) sync(workspaceName string) {
+	deployment, err := w.deployments.Deployments(config.DeploymentNamespace()).Get(workspaceName)
+	if apierrors.IsNotFound(err) {
+		w.publish(workspaceName, nil)
+		return
+	}
+
+	if err != nil {
+		log.Printf("failed to get cached deployment of workspace %s: %v\n", workspaceName, err)
+		return
+	}
+
+	w.publish(workspaceName, &watchedWorkspace{
+		workspace: w.workspaceInfo(deployment),
+		labels:    deployment.Labels,
+	})
+}
+
+// publish passes the change of the workspace on to the subscribers, a nil
+// state meaning it has been deleted. Subscribers that don't keep up are
+// dropped, which ends their stream.
+func (w *workspaceWatcher) publish(workspaceName string, current *watchedWorkspace) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	last, known := w.workspaces[workspaceName]
+
+	var event workspaceEvent
+	switch {
+	case current == nil && !known:
+		return
+	case current == nil:
+		delete(w.workspaces, workspaceName)
+
+		workspace := last.workspace
+		workspace.Status = workspaceStatusStopped
+		workspace.StatusReason = ""
+
+		event = workspaceEvent{Type: workspaceEventDeleted, Workspace: workspace, labels: last.labels}
+	case !known:
+		w.workspaces[workspaceName] = *current
+		event = workspaceEvent{Type: workspaceEventCreated, Workspace: current.workspace, labels: current.labels}
+	case reflect.DeepEqual(last, *current):
+		return
+	default:
+		w.workspaces[workspaceName] = *current
+		event = workspaceEvent{Type: workspaceEventUpdated, Workspace: current.workspace, labels: current.labels}
+	}
+
+	for subscriber := range w.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			delete(w.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// subscribe starts the informer if it isn't running yet and returns the
+// channel the events are passed to along with a function that ends the
+// subscription. The informer keeps retrying to sync in the background, the
+// subscription only waits for it as long as the context allows.
+func (w *workspaceWatcher) subscribe(ctx context.Context) (<-chan workspaceEvent, func(), error) {
+	if err := w.start(); err != nil {
+		return nil, nil, err
+	}
+
+	if !cache.WaitForCacheSync(ctx.Done(), w.synced...) {
+		return nil, nil, fmt.Errorf("failed to sync workspace informer: %w", ctx.Err())
+	}
+
+	events := make(chan workspaceEvent, workspaceEventBuffer)
+
+	w.mutex.Lock()
+	if w.subscribers == nil {
+		w.subscribers = make(map[chan workspaceEvent]struct{})
+	}
+	w.subscribers[events] = struct{}{}
+	w.mutex.Unlock()
+
+	unsubscribe := func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
+
+		if _, ok := w.subscribers[events]; ok {
+			delete(w.subscribers, events)
+			close(events)
+		}
+	}
+
+	return events, unsubscribe, nil
+}
+
+// the stream is kept open through proxies by sending a comment from time to
+// time
+const workspaceEventsKeepAlive = 30 * time.Second
+
+// workspaceEventsHandler streams the events of the caller's workspaces as
+// server-sent events until the client goes away.
+func (p *poddy) workspaceEventsHandler(c *gin.Context) {
+	owners, err := p.sessionWorkspaceOwners(c)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	events, unsubscribe, err := p.workspaceWatcher.subscribe(c.Request.Context())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, fmt.Errorf("failed to watch workspaces: %w", err))
+		return
+	}
+
+	defer unsubscribe()
+
+	// nginx would otherwise buffer the event stream
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Header("Content-Type", "text/event-stream")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(workspaceEventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-keepAlive.C:
+			c.Writer.WriteString(": keep-alive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+
+			if !ownsWorkspaceEvent(owners, event) {
+				continue
+			}
+
+			c.SSEvent(string(event.Type), event.Workspace)
+		}
+
+		c.Writer.Flush()
+	}
+}
+
+func ownsWorkspaceEvent(owners []*workspaceOwner, event workspaceEvent) bool {
+	for _, owner := range owners {
+		if owner.owns(event.labels) {
+			return true
+		}
+	}
+
+	return false
+}
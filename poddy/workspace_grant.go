@@ -0,0 +1,141 @@
+package poddy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dogboy21/poddy/config"
+	"github.com/gorilla/securecookie"
+)
+
+// workspaceCookieName is the cookie that authenticates the requests to a
+// single workspace host. The poddy session cookie stays on the poddy host, so
+// neither the workspaces nor the ingress in front of them get to see it.
+const workspaceCookieName = "poddy_workspace"
+
+// how long the workspace cookie is valid before the user is sent through the
+// signin again, which checks the poddy session once more
+const workspaceCookieLifetime = time.Hour
+
+// workspaceTicketParam is the query parameter the signin passes the ticket to
+// the workspace host in, which exchanges it for the workspace cookie.
+const workspaceTicketParam = "poddy_ticket"
+
+// the ticket only has to survive the redirect to the workspace host
+const workspaceTicketLifetime = time.Minute
+
+// workspaceGrant lets the bearer access the workspace served from the host.
+// The owner label the workspace had when the grant was issued is kept, so the
+// grant doesn't carry over to a workspace of the same name created later on
+// by someone else.
+type workspaceGrant struct {
+	Host      string
+	Owner     string
+	Username  string
+	ExpiresAt time.Time
+}
+
+var workspaceGrantCodecs []securecookie.Codec
+
+func setWorkspaceGrantKeys(keyPairs [][]byte) {
+	workspaceGrantCodecs = securecookie.CodecsFromPairs(keyPairs...)
+	for _, codec := range workspaceGrantCodecs {
+		codec.(*securecookie.SecureCookie).MaxAge(int(workspaceCookieLifetime.Seconds()))
+	}
+}
+
+func normalizeHost(host string) string {
+	return strings.ToLower(host)
+}
+
+// encodeWorkspaceGrant signs and encrypts the grant. The name keeps tickets
+// and cookies apart, so neither can be used in place of the other.
+func encodeWorkspaceGrant(name string, grant *workspaceGrant) (string, error) {
+	return securecookie.EncodeMulti(name, grant, workspaceGrantCodecs...)
+}
+
+// decodeWorkspaceGrant returns the grant if it is valid for the host and
+// hasn't expired yet, nil otherwise.
+func decodeWorkspaceGrant(name string, value string, host string) *workspaceGrant {
+	grant := workspaceGrant{}
+	if err := securecookie.DecodeMulti(name, value, &grant, workspaceGrantCodecs...); err != nil {
+		return nil
+	}
+
+	if grant.Host != normalizeHost(host) || time.Now().After(grant.ExpiresAt) {
+		return nil
+	}
+
+	return &grant
+}
+
+// workspaceTicketUrl returns the url of the workspace with a ticket for the
+// grant attached.
+func workspaceTicketUrl(returnTo *url.URL, grant *workspaceGrant) (*url.URL, error) {
+	ticket := *grant
+	ticket.ExpiresAt = time.Now().Add(workspaceTicketLifetime)
+
+	encodedTicket, err := encodeWorkspaceGrant(workspaceTicketParam, &ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	ticketUrl := *returnTo
+	query := ticketUrl.Query()
+	query.Set(workspaceTicketParam, encodedTicket)
+	ticketUrl.RawQuery = query.Encode()
+
+	return &ticketUrl, nil
+}
+
+// redeemWorkspaceTicket exchanges the ticket in the url, if there is a valid
+// one, for the workspace cookie. The url is returned without the ticket.
+func redeemWorkspaceTicket(requestUrl *url.URL) (*workspaceGrant, *http.Cookie, *url.URL) {
+	query := requestUrl.Query()
+	if len(query.Get(workspaceTicketParam)) == 0 {
+		return nil, nil, nil
+	}
+
+	ticket := decodeWorkspaceGrant(workspaceTicketParam, query.Get(workspaceTicketParam), requestUrl.Host)
+	if ticket == nil {
+		return nil, nil, nil
+	}
+
+	grant := *ticket
+	grant.ExpiresAt = time.Now().Add(workspaceCookieLifetime)
+
+	value, err := encodeWorkspaceGrant(workspaceCookieName, &grant)
+	if err != nil {
+		return nil, nil, nil
+	}
+
+	query.Del(workspaceTicketParam)
+	cleanUrl := *requestUrl
+	cleanUrl.RawQuery = query.Encode()
+
+	// without a domain the cookie is only sent to the workspace host itself
+	cookie := &http.Cookie{
+		Name:     workspaceCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(workspaceCookieLifetime.Seconds()),
+		Secure:   config.ServerSecureCookies(),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	return &grant, cookie, &cleanUrl
+}
+
+// readWorkspaceGrant returns the grant of the workspace cookie sent along
+// with the request if it is valid for the host.
+func readWorkspaceGrant(r *http.Request, host string) *workspaceGrant {
+	cookie, err := r.Cookie(workspaceCookieName)
+	if err != nil {
+		return nil
+	}
+
+	return decodeWorkspaceGrant(workspaceCookieName, cookie.Value, host)
+}
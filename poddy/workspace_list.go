@@ -0,0 +1,156 @@
+package poddy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dogboy21/poddy/config"
+	"github.com/gin-gonic/gin"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+type workspaceInfo struct {
+	Provider     string            `json:"provider"`
+	Name         string            `json:"name"`
+	Url          string            `json:"url"`
+	Project      string            `json:"project"`
+	Ref          string            `json:"ref,omitempty"`
+	RefType      string            `json:"ref_type,omitempty"`
+	MergeRequest int               `json:"merge_request,omitempty"`
+	Commit       string            `json:"commit,omitempty"`
+	Ide          string            `json:"ide"`
+	CreatedAt    time.Time         `json:"created_at"`
+	ServiceUrls  map[string]string `json:"service_urls"`
+	Status       workspaceStatus   `json:"status"`
+	StatusReason string            `json:"status_reason,omitempty"`
+}
+
+type workspaceListResponse struct {
+	Items []workspaceInfo `json:"items"`
+}
+
+// workspaceUrl returns the url a workspace host is served at, which uses the
+// scheme of poddy itself.
+func workspaceUrl(host string) string {
+	return fmt.Sprintf("%s://%s", config.ServerUrl().Scheme, host)
+}
+
+// workspaceInfoFromDeployment reads the metadata recorded on the deployment
+// of a workspace. Workspaces created before the metadata was recorded only
+// carry what can be derived from the deployment itself.
+func workspaceInfoFromDeployment(deployment *appsv1.Deployment) workspaceInfo {
+	annotations := deployment.Annotations
+	workspaceName := deployment.Labels["workspace-name"]
+	host := fmt.Sprintf("%s.%s", workspaceName, config.DeploymentBaseDomain())
+
+	info := workspaceInfo{
+		Provider:  annotations["workspace-owner-provider"],
+		Name:      workspaceName,
+		Url:       workspaceUrl(host),
+		Project:   annotations["workspace-project"],
+		Ref:       annotations["workspace-ref"],
+		RefType:   annotations["workspace-ref-type"],
+		Commit:    annotations["workspace-commit"],
+		Ide:       annotations["workspace-ide"],
+		CreatedAt: deployment.CreationTimestamp.Time,
+	}
+
+	if mergeRequest, err := strconv.Atoi(annotations["workspace-merge-request"]); err == nil {
+		info.MergeRequest = mergeRequest
+	}
+
+	if createdAt, err := time.Parse(time.RFC3339, annotations["workspace-created-at"]); err == nil {
+		info.CreatedAt = createdAt
+	}
+
+	if err := json.Unmarshal([]byte(annotations["workspace-service-urls"]), &info.ServiceUrls); err != nil || info.ServiceUrls == nil {
+		info.ServiceUrls = map[string]string{
+			"server": info.Url,
+		}
+	}
+
+	return info
+}
+
+// workspaceListQuery filters and sorts the workspace list. Workspaces can be
+// filtered by project and age and sorted by project or creation time, which
+// is descending if the sort key is prefixed with a dash.
+type workspaceListQuery struct {
+	Project    string
+	MinAge     time.Duration
+	MaxAge     time.Duration
+	Sort       string
+	Descending bool
+}
+
+func parseWorkspaceListQuery(c *gin.Context) (*workspaceListQuery, error) {
+	query := &workspaceListQuery{
+		Project: c.Query("project"),
+	}
+
+	for name, target := range map[string]*time.Duration{"min_age": &query.MinAge, "max_age": &query.MaxAge} {
+		value := c.Query(name)
+		if len(value) == 0 {
+			continue
+		}
+
+		duration, err := time.ParseDuration(value)
+		if err != nil || duration < 0 {
+			return nil, fmt.Errorf("invalid %s %q", name, value)
+		}
+
+		*target = duration
+	}
+
+	sortKey := c.DefaultQuery("sort", "-created_at")
+	query.Descending = strings.HasPrefix(sortKey, "-")
+	query.Sort = strings.TrimPrefix(sortKey, "-")
+
+	if query.Sort != "created_at" && query.Sort != "project" {
+		return nil, fmt.Errorf("invalid sort %q, either created_at or project", sortKey)
+	}
+
+	return query, nil
+}
+
+func (q *workspaceListQuery) apply(workspaces []workspaceInfo) []workspaceInfo {
+	now := time.Now()
+
+	filtered := make([]workspaceInfo, 0, len(workspaces))
+	for _, workspace := range workspaces {
+		age := now.Sub(workspace.CreatedAt)
+
+		if len(q.Project) > 0 && !strings.EqualFold(workspace.Project, q.Project) {
+			continue
+		}
+
+		if (q.MinAge > 0 && age < q.MinAge) || (q.MaxAge > 0 && age > q.MaxAge) {
+			continue
+		}
+
+		filtered = append(filtered, workspace)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		a, b := filtered[i], filtered[j]
+		if q.Descending {
+			a, b = b, a
+		}
+
+		if q.Sort == "project" && a.Project != b.Project {
+			return a.Project < b.Project
+		}
+
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+
+		return a.Name < b.Name
+	})
+
+	return filtered
+}
@@ -0,0 +1,210 @@
+package poddy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dogboy21/poddy/config"
+	"github.com/dogboy21/poddy/models"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// how often the pod is checked while waiting for a container to start
+const workspaceLogsPollInterval = 2 * time.Second
+
+// the environment variables of the workspace containers whose values must not
+// show up in the streamed logs
+var secretEnvVars = []string{"ACCESS_TOKEN", "SSH_PRIVATE_KEY"}
+
+const scrubbedSecret = "********"
+
+// workspaceLogStream reads the log of a workspace container line by line with
+// the secrets of the workspace scrubbed from it.
+type workspaceLogStream struct {
+	logs    io.ReadCloser
+	secrets []string
+}
+
+func (w *workspaceLogStream) Close() error {
+	return w.logs.Close()
+}
+
+// each calls the function for every line of the log until it returns false or
+// the log ends.
+func (w *workspaceLogStream) each(fn func(line string) bool) error {
+	scanner := bufio.NewScanner(w.logs)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if !fn(scrubSecrets(scanner.Text(), w.secrets)) {
+			return nil
+		}
+	}
+
+	return scanner.Err()
+}
+
+func scrubSecrets(line string, secrets []string) string {
+	for _, secret := range secrets {
+		line = strings.ReplaceAll(line, secret, scrubbedSecret)
+	}
+
+	return line
+}
+
+// podSecrets returns the values of the secret environment variables of the
+// pod's containers. The log is scrubbed line by line, so multi-line values
+// like ssh keys are returned as their lines.
+func podSecrets(pod *corev1.Pod) []string {
+	var secrets []string
+
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			for _, name := range secretEnvVars {
+				if env.Name != name {
+					continue
+				}
+
+				for _, line := range strings.Split(env.Value, "\n") {
+					if line = strings.TrimSpace(line); len(line) > 0 {
+						secrets = append(secrets, line)
+					}
+				}
+			}
+		}
+	}
+
+	return secrets
+}
+
+// containerStarted reports whether the container of the pod has a log yet,
+// which crash looping containers have from their last run.
+func containerStarted(pod *corev1.Pod, containerName string) (bool, bool) {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.Name == containerName {
+			return status.State.Running != nil || status.State.Terminated != nil || status.LastTerminationState.Terminated != nil, true
+		}
+	}
+
+	for _, container := range append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...) {
+		if container.Name == containerName {
+			return false, true
+		}
+	}
+
+	return false, false
+}
+
+// listWorkspacePods lists the pods of the workspace within the request
+// timeout, which the client used for streaming logs doesn't apply.
+func listWorkspacePods(ctx context.Context, clientSet kubernetes.Interface, workspaceName string) ([]corev1.Pod, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.TimeoutsKubernetesRequest())
+	defer cancel()
+
+	podList, err := clientSet.CoreV1().Pods(config.DeploymentNamespace()).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("managed-by=poddy,workspace-name=%s", workspaceName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	return podList.Items, nil
+}
+
+// workspaceLogs streams the log of a container of the workspace's pod, by
+// default of the IDE. When following the log, the status of the workspace is
+// passed to the waiting function until the container has started. The
+// request timeout isn't applied as followed logs stay open until the client
+// goes away.
+func workspaceLogs(ctx context.Context, workspaceName string, owner *workspaceOwner, containerName string, follow bool, waiting func(status workspaceStatus, reason string)) (*workspaceLogStream, error) {
+	kubernetesConfig, err := getKubernetesConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kubernetes config: %w", err)
+	}
+
+	kubernetesConfig = rest.CopyConfig(kubernetesConfig)
+	kubernetesConfig.Timeout = 0
+
+	clientSet, err := kubernetes.NewForConfig(kubernetesConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, config.TimeoutsKubernetesRequest())
+	defer cancel()
+
+	deployment, err := getOwnedWorkspace(listCtx, clientSet, workspaceName, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	var pod *corev1.Pod
+	for {
+		pods, err := listWorkspacePods(ctx, clientSet, workspaceName)
+		if err != nil {
+			return nil, err
+		}
+
+		pod = workspacePod(pods)
+		if pod != nil && len(containerName) == 0 {
+			containerName = pod.Spec.Containers[0].Name
+		}
+
+		if pod != nil {
+			started, exists := containerStarted(pod, containerName)
+			if !exists {
+				return nil, fmt.Errorf("workspace %s has no container %s: %w", workspaceName, containerName, models.ErrNotFound)
+			}
+
+			if started {
+				break
+			}
+		}
+
+		if !follow && pod == nil {
+			return nil, fmt.Errorf("failed to find pod of workspace %s: %w", workspaceName, models.ErrNotFound)
+		}
+
+		if !follow {
+			return nil, fmt.Errorf("container %s of workspace %s hasn't started yet: %w", containerName, workspaceName, models.ErrNotFound)
+		}
+
+		statusCtx, statusCancel := context.WithTimeout(ctx, config.TimeoutsKubernetesRequest())
+		status, reason := getWorkspaceStatus(statusCtx, clientSet, deployment, pods)
+		statusCancel()
+
+		if status == workspaceStatusFailed || status == workspaceStatusStopped {
+			return nil, fmt.Errorf("workspace %s is %s: %s", workspaceName, status, reason)
+		}
+
+		waiting(status, reason)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(workspaceLogsPollInterval):
+		}
+	}
+
+	logs, err := clientSet.CoreV1().Pods(config.DeploymentNamespace()).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: containerName,
+		Follow:    follow,
+	}).Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream logs of container %s: %w", containerName, err)
+	}
+
+	return &workspaceLogStream{
+		logs:    logs,
+		secrets: podSecrets(pod),
+	}, nil
+}
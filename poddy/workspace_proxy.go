@@ -0,0 +1,121 @@
+package poddy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dogboy21/poddy/config"
+	"github.com/dogboy21/poddy/models"
+	"github.com/gin-gonic/gin"
+)
+
+// isWorkspaceHost reports whether the request is for a workspace rather than
+// for poddy itself, which may be served from below the base domain as well.
+func isWorkspaceHost(host string) bool {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return !strings.EqualFold(host, config.ServerUrl().Hostname()) && workspaceNameCandidates(host) != nil
+}
+
+// hostRouter sends requests for workspace hosts to the workspace proxy and
+// everything else to the poddy routes.
+func hostRouter(app http.Handler, workspaceProxy http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isWorkspaceHost(r.Host) {
+			workspaceProxy.ServeHTTP(w, r)
+			return
+		}
+
+		app.ServeHTTP(w, r)
+	})
+}
+
+// removeCookie keeps the workspace cookie from being passed on to the
+// workspace.
+func removeCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+
+	for _, cookie := range cookies {
+		if cookie.Name != name {
+			r.AddCookie(cookie)
+		}
+	}
+}
+
+// workspaceProxyHandler proxies requests, including WebSocket upgrades, to
+// the service of the workspace the host belongs to once the workspace cookie
+// has been checked against the workspace's owner.
+func (p *poddy) workspaceProxyHandler(c *gin.Context) {
+	original := &url.URL{
+		Scheme:   config.ServerUrl().Scheme,
+		Host:     c.Request.Host,
+		Path:     c.Request.URL.Path,
+		RawPath:  c.Request.URL.RawPath,
+		RawQuery: c.Request.URL.RawQuery,
+	}
+
+	if _, cookie, cleanUrl := redeemWorkspaceTicket(original); cookie != nil {
+		http.SetCookie(c.Writer, cookie)
+		c.Redirect(http.StatusFound, cleanUrl.String())
+		c.Abort()
+		return
+	}
+
+	grant := readWorkspaceGrant(c.Request, c.Request.Host)
+	if grant == nil {
+		if c.Request.Method != http.MethodGet {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		c.Redirect(http.StatusFound, workspaceSigninUrl(original).String())
+		c.Abort()
+		return
+	}
+
+	endpoint, portName, err := p.authorizeWorkspaceGrant(c.Request.Context(), grant)
+	if errors.Is(err, models.ErrForbidden) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	port, ok := endpoint.Ports[portName]
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	target := &url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s.%s.svc:%d", endpoint.Name, config.DeploymentNamespace(), port),
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.FlushInterval = 100 * time.Millisecond
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		log.Printf("failed to proxy request to workspace %s: %v\n", endpoint.Name, err)
+		w.WriteHeader(http.StatusBadGateway)
+	}
+
+	removeCookie(c.Request, workspaceCookieName)
+	c.Request.Header.Set("X-Forwarded-Host", c.Request.Host)
+	c.Request.Header.Set("X-Forwarded-Proto", config.ServerUrl().Scheme)
+	c.Request.Header.Set("X-Poddy-User", grant.Username)
+
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
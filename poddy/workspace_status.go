@@ -0,0 +1,288 @@
+package poddy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dogboy21/poddy/config"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+type workspaceStatus string
+
+const (
+	// the pod hasn't been scheduled or created yet
+	workspaceStatusPending workspaceStatus = "pending"
+	// the workspace-setup init container is cloning the repository
+	workspaceStatusCloning workspaceStatus = "cloning"
+	// the IDE is starting but not ready yet
+	workspaceStatusStarting workspaceStatus = "starting"
+	workspaceStatusRunning  workspaceStatus = "running"
+	// the workspace can't start without someone stepping in
+	workspaceStatusFailed  workspaceStatus = "failed"
+	workspaceStatusStopped workspaceStatus = "stopped"
+)
+
+const workspaceSetupContainer = "workspace-setup"
+
+// container waiting reasons the kubelet doesn't recover from on its own
+var failedWaitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"CrashLoopBackOff":           true,
+}
+
+// workspacePod returns the newest pod of the workspace that isn't being
+// deleted, or nil if there is none.
+func workspacePod(pods []corev1.Pod) *corev1.Pod {
+	var newest *corev1.Pod
+	for i := range pods {
+		if pods[i].DeletionTimestamp != nil {
+			continue
+		}
+
+		if newest == nil || pods[i].CreationTimestamp.After(newest.CreationTimestamp.Time) {
+			newest = &pods[i]
+		}
+	}
+
+	return newest
+}
+
+// containerReason translates the state of a failed container into a reason
+// for the user.
+func containerReason(container corev1.Container, status corev1.ContainerStatus) string {
+	if waiting := status.State.Waiting; waiting != nil {
+		switch waiting.Reason {
+		case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+			return fmt.Sprintf("image %s of container %s can't be pulled", container.Image, container.Name)
+		case "CrashLoopBackOff":
+			if terminated := status.LastTerminationState.Terminated; terminated != nil {
+				return fmt.Sprintf("container %s keeps crashing with exit code %d", container.Name, terminated.ExitCode)
+			}
+
+			return fmt.Sprintf("container %s keeps crashing", container.Name)
+		}
+
+		if len(waiting.Message) > 0 {
+			return fmt.Sprintf("container %s can't be created: %s", container.Name, waiting.Message)
+		}
+
+		return fmt.Sprintf("container %s can't be created: %s", container.Name, waiting.Reason)
+	}
+
+	if terminated := status.State.Terminated; terminated != nil {
+		return fmt.Sprintf("container %s exited with code %d", container.Name, terminated.ExitCode)
+	}
+
+	return ""
+}
+
+// setupFailedReason describes a failed workspace-setup init container, which
+// fails when the repository can't be cloned.
+func setupFailedReason(container corev1.Container, status corev1.ContainerStatus) string {
+	terminated := status.State.Terminated
+	if terminated == nil {
+		terminated = status.LastTerminationState.Terminated
+	}
+
+	if container.Name == workspaceSetupContainer && terminated != nil && terminated.ExitCode != 0 {
+		return fmt.Sprintf("cloning the repository failed with exit code %d", terminated.ExitCode)
+	}
+
+	return containerReason(container, status)
+}
+
+func findContainer(containers []corev1.Container, name string) corev1.Container {
+	for _, container := range containers {
+		if container.Name == name {
+			return container
+		}
+	}
+
+	return corev1.Container{Name: name}
+}
+
+// deriveWorkspaceStatus derives the status of a workspace from the state of
+// its deployment and pod. The reason is empty if there is nothing to tell
+// beyond the status or if the reason has to be looked up in the events.
+func deriveWorkspaceStatus(deployment *appsv1.Deployment, pod *corev1.Pod) (workspaceStatus, string) {
+	if deployment.DeletionTimestamp != nil {
+		return workspaceStatusStopped, "the workspace is being deleted"
+	}
+
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		return workspaceStatusStopped, "the workspace has been scaled down"
+	}
+
+	if pod == nil {
+		for _, condition := range deployment.Status.Conditions {
+			if condition.Type == appsv1.DeploymentReplicaFailure && condition.Status == corev1.ConditionTrue {
+				return workspaceStatusFailed, condition.Message
+			}
+		}
+
+		return workspaceStatusPending, ""
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return workspaceStatusStopped, "the IDE has exited"
+	case corev1.PodFailed:
+		if len(pod.Status.Message) > 0 {
+			return workspaceStatusFailed, pod.Status.Message
+		}
+
+		return workspaceStatusFailed, ""
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+			if condition.Reason == corev1.PodReasonUnschedulable {
+				return workspaceStatusPending, fmt.Sprintf("no node can run the workspace: %s", condition.Message)
+			}
+
+			return workspaceStatusPending, ""
+		}
+	}
+
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.State.Terminated != nil && status.State.Terminated.ExitCode == 0 {
+			continue
+		}
+
+		container := findContainer(pod.Spec.InitContainers, status.Name)
+
+		if status.State.Terminated != nil || (status.State.Waiting != nil && failedWaitingReasons[status.State.Waiting.Reason]) {
+			return workspaceStatusFailed, setupFailedReason(container, status)
+		}
+
+		if status.Name == workspaceSetupContainer {
+			return workspaceStatusCloning, ""
+		}
+
+		return workspaceStatusStarting, ""
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting != nil && failedWaitingReasons[status.State.Waiting.Reason] {
+			return workspaceStatusFailed, containerReason(findContainer(pod.Spec.Containers, status.Name), status)
+		}
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+			return workspaceStatusRunning, ""
+		}
+	}
+
+	// the init container statuses are only reported once the pod is
+	// scheduled, so the setup hasn't started yet
+	if len(pod.Status.InitContainerStatuses) == 0 && len(pod.Spec.InitContainers) > 0 {
+		return workspaceStatusPending, ""
+	}
+
+	return workspaceStatusStarting, ""
+}
+
+// eventReason translates a warning event of the workspace's pod into a
+// reason for the user.
+func eventReason(event corev1.Event) string {
+	switch event.Reason {
+	case "FailedScheduling":
+		return fmt.Sprintf("no node can run the workspace: %s", event.Message)
+	case "Failed":
+		if strings.Contains(event.Message, "not found") || strings.Contains(event.Message, "manifest unknown") {
+			return fmt.Sprintf("image not found: %s", event.Message)
+		}
+	case "FailedMount", "FailedAttachVolume":
+		return fmt.Sprintf("volume can't be mounted: %s", event.Message)
+	}
+
+	return event.Message
+}
+
+// eventTime returns when the event last occurred, which is recorded in
+// different fields depending on the API the event was created through.
+func eventTime(event corev1.Event) time.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp.Time
+	}
+
+	if !event.EventTime.IsZero() {
+		return event.EventTime.Time
+	}
+
+	return event.CreationTimestamp.Time
+}
+
+// listWarningEvents lists the warning events of the pod.
+func listWarningEvents(ctx context.Context, clientSet kubernetes.Interface, pod *corev1.Pod) ([]corev1.Event, error) {
+	eventList, err := clientSet.CoreV1().Events(config.DeploymentNamespace()).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.Set{
+			"involvedObject.kind": "Pod",
+			"involvedObject.name": pod.Name,
+			"involvedObject.uid":  string(pod.UID),
+			"type":                corev1.EventTypeWarning,
+		}.String(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+
+	return eventList.Items, nil
+}
+
+// latestWarningReason returns the reason of the latest of the warning events,
+// if there is any.
+func latestWarningReason(events []corev1.Event) string {
+	if len(events) == 0 {
+		return ""
+	}
+
+	events = append([]corev1.Event{}, events...)
+	sort.Slice(events, func(i, j int) bool {
+		return eventTime(events[i]).Before(eventTime(events[j]))
+	})
+
+	return eventReason(events[len(events)-1])
+}
+
+// explainWorkspaceStatus derives the status of a workspace from its pods.
+// Workspaces that are stuck are explained through the warning events of their
+// pod if the pod itself doesn't tell what's wrong. The events only add to the
+// status, so failing to get them leaves the reason empty.
+func explainWorkspaceStatus(deployment *appsv1.Deployment, pods []corev1.Pod, warningEvents func(pod *corev1.Pod) ([]corev1.Event, error)) (workspaceStatus, string) {
+	pod := workspacePod(pods)
+	status, reason := deriveWorkspaceStatus(deployment, pod)
+
+	if pod != nil && len(reason) == 0 && (status == workspaceStatusPending || status == workspaceStatusFailed) {
+		events, err := warningEvents(pod)
+		if err != nil {
+			log.Printf("failed to get reason for status of workspace %s: %v\n", deployment.Name, err)
+		}
+
+		reason = latestWarningReason(events)
+	}
+
+	return status, reason
+}
+
+// getWorkspaceStatus derives the status of a workspace from its pods and looks
+// up the events explaining it through the API.
+func getWorkspaceStatus(ctx context.Context, clientSet kubernetes.Interface, deployment *appsv1.Deployment, pods []corev1.Pod) (workspaceStatus, string) {
+	return explainWorkspaceStatus(deployment, pods, func(pod *corev1.Pod) ([]corev1.Event, error) {
+		return listWarningEvents(ctx, clientSet, pod)
+	})
+}
@@ -0,0 +1,200 @@
+package poddy
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testWorkspacePod(status corev1.PodStatus) *corev1.Pod {
+	return &corev1.Pod{
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: workspaceSetupContainer, Image: "poddy/setup"}},
+			Containers:     []corev1.Container{{Name: "code-server", Image: "codercom/code-server"}},
+		},
+		Status: status,
+	}
+}
+
+func TestDeriveWorkspaceStatus(t *testing.T) {
+	now := metav1.Now()
+
+	setupDone := corev1.ContainerStatus{
+		Name:  workspaceSetupContainer,
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}},
+	}
+
+	tests := []struct {
+		name       string
+		deployment *appsv1.Deployment
+		pod        *corev1.Pod
+		wantStatus workspaceStatus
+		wantReason string
+	}{
+		{
+			name:       "being deleted",
+			deployment: &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{DeletionTimestamp: &now}},
+			wantStatus: workspaceStatusStopped,
+			wantReason: "the workspace is being deleted",
+		},
+		{
+			name:       "scaled down",
+			deployment: &appsv1.Deployment{Spec: appsv1.DeploymentSpec{Replicas: int32Pointer(0)}},
+			wantStatus: workspaceStatusStopped,
+			wantReason: "the workspace has been scaled down",
+		},
+		{
+			name:       "no pod yet",
+			deployment: &appsv1.Deployment{},
+			wantStatus: workspaceStatusPending,
+		},
+		{
+			name: "replica failure",
+			deployment: &appsv1.Deployment{Status: appsv1.DeploymentStatus{Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentReplicaFailure, Status: corev1.ConditionTrue, Message: "exceeded quota"},
+			}}},
+			wantStatus: workspaceStatusFailed,
+			wantReason: "exceeded quota",
+		},
+		{
+			name:       "ide exited",
+			deployment: &appsv1.Deployment{},
+			pod:        testWorkspacePod(corev1.PodStatus{Phase: corev1.PodSucceeded}),
+			wantStatus: workspaceStatusStopped,
+			wantReason: "the IDE has exited",
+		},
+		{
+			name:       "pod failed",
+			deployment: &appsv1.Deployment{},
+			pod:        testWorkspacePod(corev1.PodStatus{Phase: corev1.PodFailed, Message: "evicted"}),
+			wantStatus: workspaceStatusFailed,
+			wantReason: "evicted",
+		},
+		{
+			name:       "unschedulable",
+			deployment: &appsv1.Deployment{},
+			pod: testWorkspacePod(corev1.PodStatus{Phase: corev1.PodPending, Conditions: []corev1.PodCondition{
+				{Type: corev1.PodScheduled, Status: corev1.ConditionFalse, Reason: corev1.PodReasonUnschedulable, Message: "0/3 nodes are available"},
+			}}),
+			wantStatus: workspaceStatusPending,
+			wantReason: "no node can run the workspace: 0/3 nodes are available",
+		},
+		{
+			name:       "not scheduled yet",
+			deployment: &appsv1.Deployment{},
+			pod:        testWorkspacePod(corev1.PodStatus{Phase: corev1.PodPending}),
+			wantStatus: workspaceStatusPending,
+		},
+		{
+			name:       "cloning",
+			deployment: &appsv1.Deployment{},
+			pod: testWorkspacePod(corev1.PodStatus{Phase: corev1.PodPending, InitContainerStatuses: []corev1.ContainerStatus{
+				{Name: workspaceSetupContainer, State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			}}),
+			wantStatus: workspaceStatusCloning,
+		},
+		{
+			name:       "clone failed",
+			deployment: &appsv1.Deployment{},
+			pod: testWorkspacePod(corev1.PodStatus{Phase: corev1.PodPending, InitContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name:                 workspaceSetupContainer,
+					State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+					LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 128}},
+				},
+			}}),
+			wantStatus: workspaceStatusFailed,
+			wantReason: "cloning the repository failed with exit code 128",
+		},
+		{
+			name:       "image can't be pulled",
+			deployment: &appsv1.Deployment{},
+			pod: testWorkspacePod(corev1.PodStatus{
+				Phase:                 corev1.PodPending,
+				InitContainerStatuses: []corev1.ContainerStatus{setupDone},
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "code-server", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff"}}},
+				},
+			}),
+			wantStatus: workspaceStatusFailed,
+			wantReason: "image codercom/code-server of container code-server can't be pulled",
+		},
+		{
+			name:       "starting",
+			deployment: &appsv1.Deployment{},
+			pod: testWorkspacePod(corev1.PodStatus{
+				Phase:                 corev1.PodRunning,
+				InitContainerStatuses: []corev1.ContainerStatus{setupDone},
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "code-server", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+				},
+			}),
+			wantStatus: workspaceStatusStarting,
+		},
+		{
+			name:       "running",
+			deployment: &appsv1.Deployment{},
+			pod: testWorkspacePod(corev1.PodStatus{
+				Phase:                 corev1.PodRunning,
+				InitContainerStatuses: []corev1.ContainerStatus{setupDone},
+				Conditions: []corev1.PodCondition{
+					{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+				},
+			}),
+			wantStatus: workspaceStatusRunning,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, reason := deriveWorkspaceStatus(tt.deployment, tt.pod)
+			if status != tt.wantStatus || reason != tt.wantReason {
+				t.Errorf("deriveWorkspaceStatus() = %s, %q, want %s, %q", status, reason, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestEventReason(t *testing.T) {
+	tests := []struct {
+		name  string
+		event corev1.Event
+		want  string
+	}{
+		{
+			name:  "failed scheduling",
+			event: corev1.Event{Reason: "FailedScheduling", Message: "0/3 nodes are available"},
+			want:  "no node can run the workspace: 0/3 nodes are available",
+		},
+		{
+			name:  "image not found",
+			event: corev1.Event{Reason: "Failed", Message: "rpc error: manifest unknown"},
+			want:  "image not found: rpc error: manifest unknown",
+		},
+		{
+			name:  "other failure",
+			event: corev1.Event{Reason: "Failed", Message: "Error: context deadline exceeded"},
+			want:  "Error: context deadline exceeded",
+		},
+		{
+			name:  "failed mount",
+			event: corev1.Event{Reason: "FailedMount", Message: "secret not ready"},
+			want:  "volume can't be mounted: secret not ready",
+		},
+		{
+			name:  "unknown reason",
+			event: corev1.Event{Reason: "BackOff", Message: "Back-off restarting failed container"},
+			want:  "Back-off restarting failed container",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eventReason(tt.event); got != tt.want {
+				t.Errorf("eventReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,12 @@ package poddy
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dogboy21/poddy/config"
 	"github.com/dogboy21/poddy/models"
@@ -11,6 +16,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -18,20 +24,20 @@ import (
 )
 
 func getKubernetesConfig() (*rest.Config, error) {
-	inClusterConfig, err := rest.InClusterConfig()
+	kubernetesConfig, err := rest.InClusterConfig()
 	if err != nil {
 		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
 		configOverrides := &clientcmd.ConfigOverrides{}
 		kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
-		config, err := kubeConfig.ClientConfig()
+		kubernetesConfig, err = kubeConfig.ClientConfig()
 		if err != nil {
 			return nil, err
 		}
-
-		return config, nil
 	}
 
-	return inClusterConfig, nil
+	kubernetesConfig.Timeout = config.TimeoutsKubernetesRequest()
+
+	return kubernetesConfig, nil
 }
 
 func int32Pointer(v int32) *int32 {
@@ -50,27 +56,76 @@ func pathTypePointer(pathType networkv1.PathType) *networkv1.PathType {
 	return &pathType
 }
 
-func createWorkspace(provider models.RepositoryProvider, projectSlug, projectBranch string, currentUser models.User, accessToken string) (string, string, error) {
-	project, err := provider.GetProject(projectSlug)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to get project: %v", err)
-	}
+// workspaceCreateTimeout bounds creating the deployment, service and ingress
+// of a workspace.
+func workspaceCreateTimeout() time.Duration {
+	return 3 * config.TimeoutsKubernetesRequest()
+}
 
-	if projectBranch == "" {
-		projectBranch = project.GetDefaultBranch()
+// deleteFailedWorkspace rolls back a workspace that couldn't be created
+// completely. The objects created along with the deployment are owned by it
+// and removed by the garbage collector.
+func deleteFailedWorkspace(clientSet kubernetes.Interface, deployment *appsv1.Deployment) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.TimeoutsKubernetesRequest())
+	defer cancel()
+
+	propagationPolicy := metav1.DeletePropagationBackground
+	if err := clientSet.AppsV1().Deployments(config.DeploymentNamespace()).Delete(ctx, deployment.Name, metav1.DeleteOptions{
+		PropagationPolicy: &propagationPolicy,
+	}); err != nil && !apierrors.IsNotFound(err) {
+		log.Printf("failed to roll back workspace %s: %v\n", deployment.Name, err)
 	}
+}
 
-	branchExists, err := provider.DoesProjectBranchExist(projectSlug, projectBranch)
+func createWorkspace(ctx context.Context, provider models.RepositoryProvider, user models.User, projectSlug, projectRef string, mergeRequestNumber int, owner *workspaceOwner, credentials *models.GitCredentials) (string, string, error) {
+	project, err := provider.GetProject(ctx, projectSlug)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to find branch %s for project %s: %v", projectBranch, projectSlug, err)
+		return "", "", fmt.Errorf("failed to get project: %w", err)
 	}
-	if !branchExists {
-		return "", "", fmt.Errorf("no branch found for name %s", projectBranch)
+
+	checkout := &workspaceCheckout{}
+
+	if mergeRequestNumber > 0 {
+		mergeRequest, err := provider.GetMergeRequest(ctx, projectSlug, mergeRequestNumber)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get merge request %d for project %s: %w", mergeRequestNumber, projectSlug, err)
+		}
+		if mergeRequest == nil {
+			return "", "", fmt.Errorf("no merge request found for number %d: %w", mergeRequestNumber, models.ErrNotFound)
+		}
+
+		checkout.MergeRequest = mergeRequest
+		if mergeRequest.IsFromFork() {
+			checkout.Branch = mergeRequest.GetTargetBranch()
+		} else {
+			checkout.Branch = mergeRequest.GetSourceBranch()
+		}
+
+		checkout.Commit = mergeRequest.GetHeadSha()
+	} else {
+		if projectRef == "" {
+			projectRef = project.GetDefaultBranch()
+		}
+
+		resolvedRef, err := provider.ResolveRef(ctx, projectSlug, projectRef)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to resolve ref %s for project %s: %w", projectRef, projectSlug, err)
+		}
+		if resolvedRef == nil {
+			return "", "", fmt.Errorf("no branch, tag or commit found for %s: %w", projectRef, models.ErrNotFound)
+		}
+
+		checkout.Ref = resolvedRef
+		if resolvedRef.Type == models.RefTypeBranch {
+			checkout.Branch = resolvedRef.Name
+		}
+
+		checkout.Commit = resolvedRef.Commit
 	}
 
-	poddyProjectConfigFile, err := provider.GetProjectFile(projectSlug, projectBranch, ".poddy.yml")
+	poddyProjectConfigFile, err := provider.GetProjectFile(ctx, projectSlug, checkout.Commit, ".poddy.yml")
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get poddy config for project %s: %v", projectSlug, err)
+		return "", "", fmt.Errorf("failed to get poddy config for project %s: %w", projectSlug, err)
 	}
 
 	var projectConfig ProjectConfig
@@ -78,27 +133,36 @@ func createWorkspace(provider models.RepositoryProvider, projectSlug, projectBra
 		return "", "", fmt.Errorf("failed to parse poddy project config for %s: %v", projectSlug, err)
 	}
 
-	deploymentSpec, err := projectConfig.createDeploymentSpec(project, currentUser, accessToken)
+	deploymentSpec, err := projectConfig.createDeploymentSpec(project, user, credentials, checkout)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create deployment spec from project config: %v", err)
+		return "", "", fmt.Errorf("failed to create deployment spec from project config: %w", err)
 	}
 
 	kubernetesConfig, err := getKubernetesConfig()
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get Kubernetes config: %v", err)
+		return "", "", fmt.Errorf("failed to get Kubernetes config: %w", err)
 	}
 
 	clientSet, err := kubernetes.NewForConfig(kubernetesConfig)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create Kubernetes client: %v", err)
+		return "", "", fmt.Errorf("failed to create Kubernetes client: %w", err)
 	}
 
 	workspaceName := petname.Generate(5, "-")
+	ingressDomain := fmt.Sprintf("%s.%s", workspaceName, config.DeploymentBaseDomain())
+
+	serviceUrls, err := json.Marshal(projectConfig.getServiceUrls(ingressDomain))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode service urls: %w", err)
+	}
 
 	labels := map[string]string{
-		"managed-by":      "poddy",
-		"workspace-name":  workspaceName,
-		"workspace-owner": currentUser.GetUsername(),
+		"managed-by":     "poddy",
+		"workspace-name": workspaceName,
+	}
+
+	for k, v := range owner.labels() {
+		labels[k] = v
 	}
 
 	deploymentSpec.Replicas = int32Pointer(1)
@@ -111,16 +175,46 @@ func createWorkspace(provider models.RepositoryProvider, projectSlug, projectBra
 		deploymentSpec.Template.ObjectMeta.Labels[k] = v
 	}
 
-	deployment, err := clientSet.AppsV1().Deployments(config.DeploymentNamespace()).Create(context.Background(), &appsv1.Deployment{
+	annotations := map[string]string{
+		"workspace-project":      project.GetFullName(),
+		"workspace-commit":       checkout.Commit,
+		"workspace-ide":          deploymentSpec.Template.ObjectMeta.Labels["workspace-type"],
+		"workspace-created-at":   time.Now().UTC().Format(time.RFC3339),
+		"workspace-service-urls": string(serviceUrls),
+	}
+
+	for k, v := range owner.annotations() {
+		annotations[k] = v
+	}
+
+	if checkout.MergeRequest != nil {
+		annotations["workspace-merge-request"] = strconv.Itoa(checkout.MergeRequest.GetNumber())
+	}
+
+	if checkout.Ref != nil {
+		annotations["workspace-ref"] = checkout.Ref.Name
+		annotations["workspace-ref-type"] = string(checkout.Ref.Type)
+	} else if len(checkout.Branch) > 0 {
+		annotations["workspace-ref"] = checkout.Branch
+		annotations["workspace-ref-type"] = string(models.RefTypeBranch)
+	}
+
+	// the objects are created regardless of the client going away, as a
+	// workspace without its service or ingress can't be reached
+	createCtx, cancel := context.WithTimeout(context.Background(), workspaceCreateTimeout())
+	defer cancel()
+
+	deployment, err := clientSet.AppsV1().Deployments(config.DeploymentNamespace()).Create(createCtx, &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      workspaceName,
-			Namespace: config.DeploymentNamespace(),
-			Labels:    labels,
+			Name:        workspaceName,
+			Namespace:   config.DeploymentNamespace(),
+			Labels:      labels,
+			Annotations: annotations,
 		},
 		Spec: *deploymentSpec,
 	}, metav1.CreateOptions{})
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create deployment: %v", err)
+		return "", "", fmt.Errorf("failed to create deployment: %w", err)
 	}
 
 	ownerReferences := []metav1.OwnerReference{
@@ -132,7 +226,7 @@ func createWorkspace(provider models.RepositoryProvider, projectSlug, projectBra
 		},
 	}
 
-	_, err = clientSet.CoreV1().Services(config.DeploymentNamespace()).Create(context.Background(), &corev1.Service{
+	_, err = clientSet.CoreV1().Services(config.DeploymentNamespace()).Create(createCtx, &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            workspaceName,
 			Namespace:       config.DeploymentNamespace(),
@@ -146,16 +240,21 @@ func createWorkspace(provider models.RepositoryProvider, projectSlug, projectBra
 		},
 	}, metav1.CreateOptions{})
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create service for deployment: %v", err)
+		deleteFailedWorkspace(clientSet, deployment)
+		return "", "", fmt.Errorf("failed to create service for deployment: %w", err)
 	}
 
-	ingressDomain := fmt.Sprintf("%s.%s", workspaceName, config.DeploymentBaseDomain())
+	// poddy routes the workspace hosts to the service itself in proxy mode
+	if config.DeploymentAuth() == config.DeploymentAuthProxy {
+		return workspaceName, workspaceUrl(ingressDomain), nil
+	}
 
-	_, err = clientSet.NetworkingV1().Ingresses(config.DeploymentNamespace()).Create(context.Background(), &networkv1.Ingress{ //TODO Ingress Annotations
+	_, err = clientSet.NetworkingV1().Ingresses(config.DeploymentNamespace()).Create(createCtx, &networkv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            workspaceName,
 			Namespace:       config.DeploymentNamespace(),
 			Labels:          labels,
+			Annotations:     ingressAuthAnnotations(),
 			OwnerReferences: ownerReferences,
 		},
 		Spec: networkv1.IngressSpec{
@@ -164,63 +263,138 @@ func createWorkspace(provider models.RepositoryProvider, projectSlug, projectBra
 		},
 	}, metav1.CreateOptions{})
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create ingress for deployment: %v", err)
+		deleteFailedWorkspace(clientSet, deployment)
+		return "", "", fmt.Errorf("failed to create ingress for deployment: %w", err)
+	}
+
+	return workspaceName, workspaceUrl(ingressDomain), nil
+}
+
+// getOwnedWorkspace returns the deployment of the workspace if it belongs to
+// the owner. Workspaces of other users are reported as not found, so their
+// names can't be probed.
+func getOwnedWorkspace(ctx context.Context, clientSet kubernetes.Interface, workspaceName string, owner *workspaceOwner) (*appsv1.Deployment, error) {
+	deployment, err := clientSet.AppsV1().Deployments(config.DeploymentNamespace()).Get(ctx, workspaceName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) || (err == nil && !owner.owns(deployment.Labels)) {
+		return nil, fmt.Errorf("failed to find workspace %s: %w", workspaceName, models.ErrNotFound)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment: %w", err)
 	}
 
-	return workspaceName, ingressDomain, nil
+	return deployment, nil
 }
 
-func listWorkspaces(currentUser models.User) ([]map[string]string, error) {
+// workspacePodSelector selects the pods of the deployments by their workspace
+// name. The ownership is checked on the deployments, as the pods of relabelled
+// legacy workspaces keep the owner labels they were created with.
+func workspacePodSelector(deployments []appsv1.Deployment) string {
+	names := make([]string, len(deployments))
+	for i, deployment := range deployments {
+		names[i] = deployment.Name
+	}
+
+	return fmt.Sprintf("managed-by=poddy,workspace-name in (%s)", strings.Join(names, ","))
+}
+
+func listWorkspaces(ctx context.Context, owner *workspaceOwner) ([]workspaceInfo, error) {
 	kubernetesConfig, err := getKubernetesConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get Kubernetes config: %v", err)
+		return nil, fmt.Errorf("failed to get Kubernetes config: %w", err)
 	}
 
 	clientSet, err := kubernetes.NewForConfig(kubernetesConfig)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
 	}
 
-	deploymentList, err := clientSet.AppsV1().Deployments(config.DeploymentNamespace()).List(context.Background(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("managed-by=poddy,workspace-owner=%s", currentUser.GetUsername()),
+	deploymentList, err := clientSet.AppsV1().Deployments(config.DeploymentNamespace()).List(ctx, metav1.ListOptions{
+		LabelSelector: owner.labelSelector(),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to list deployments: %v", err)
+		return nil, fmt.Errorf("failed to list deployments: %w", err)
 	}
 
-	workspaceList := make([]map[string]string, len(deploymentList.Items))
-	for i := 0; i < len(workspaceList); i++ {
-		workspaceName := deploymentList.Items[i].ObjectMeta.Labels["workspace-name"]
-		workspaceList[i] = map[string]string{
-			"name": workspaceName,
-			"url":  fmt.Sprintf("%s.%s", workspaceName, config.DeploymentBaseDomain()),
+	workspacePods := make(map[string][]corev1.Pod)
+	if len(deploymentList.Items) > 0 {
+		podList, err := clientSet.CoreV1().Pods(config.DeploymentNamespace()).List(ctx, metav1.ListOptions{
+			LabelSelector: workspacePodSelector(deploymentList.Items),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
 		}
+
+		for _, pod := range podList.Items {
+			workspaceName := pod.Labels["workspace-name"]
+			workspacePods[workspaceName] = append(workspacePods[workspaceName], pod)
+		}
+	}
+
+	workspaceList := make([]workspaceInfo, len(deploymentList.Items))
+	for i := 0; i < len(workspaceList); i++ {
+		workspaceList[i] = workspaceInfoFromDeployment(&deploymentList.Items[i])
+		workspaceList[i].Provider = owner.ProviderID
+
+		workspaceList[i].Status, workspaceList[i].StatusReason = getWorkspaceStatus(ctx, clientSet, &deploymentList.Items[i], workspacePods[workspaceList[i].Name])
 	}
 
 	return workspaceList, nil
 }
 
-func deleteWorkspace(workspaceName string, currentUser models.User) error {
+// getWorkspace returns the workspace along with its status if it belongs to
+// the owner.
+func getWorkspace(ctx context.Context, workspaceName string, owner *workspaceOwner) (*workspaceInfo, error) {
 	kubernetesConfig, err := getKubernetesConfig()
 	if err != nil {
-		return fmt.Errorf("failed to get Kubernetes config: %v", err)
+		return nil, fmt.Errorf("failed to get Kubernetes config: %w", err)
 	}
 
 	clientSet, err := kubernetes.NewForConfig(kubernetesConfig)
 	if err != nil {
-		return fmt.Errorf("failed to create Kubernetes client: %v", err)
+		return nil, fmt.Errorf("failed to create Kubernetes client: %w", err)
 	}
 
-	deploymentList, err := clientSet.AppsV1().Deployments(config.DeploymentNamespace()).List(context.Background(), metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("managed-by=poddy,workspace-owner=%s", currentUser.GetUsername()),
+	deployment, err := getOwnedWorkspace(ctx, clientSet, workspaceName, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	podList, err := clientSet.CoreV1().Pods(config.DeploymentNamespace()).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("managed-by=poddy,workspace-name=%s", workspaceName),
 	})
 	if err != nil {
-		return fmt.Errorf("failed to list deployments: %v", err)
+		return nil, fmt.Errorf("failed to list pods: %w", err)
 	}
 
-	if len(deploymentList.Items) == 0 {
-		return fmt.Errorf("failed to find workspace %s", workspaceName)
+	workspace := workspaceInfoFromDeployment(deployment)
+	workspace.Provider = owner.ProviderID
+
+	workspace.Status, workspace.StatusReason = getWorkspaceStatus(ctx, clientSet, deployment, podList.Items)
+
+	return &workspace, nil
+}
+
+func deleteWorkspace(ctx context.Context, workspaceName string, owner *workspaceOwner) error {
+	kubernetesConfig, err := getKubernetesConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get Kubernetes config: %w", err)
 	}
 
-	return clientSet.AppsV1().Deployments(config.DeploymentNamespace()).Delete(context.Background(), workspaceName, metav1.DeleteOptions{})
+	clientSet, err := kubernetes.NewForConfig(kubernetesConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	deployment, err := getOwnedWorkspace(ctx, clientSet, workspaceName, owner)
+	if err != nil {
+		return err
+	}
+
+	// the precondition makes sure the checked deployment is the one deleted
+	return clientSet.AppsV1().Deployments(config.DeploymentNamespace()).Delete(ctx, workspaceName, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{
+			UID: &deployment.UID,
+		},
+	})
 }
@@ -0,0 +1,121 @@
+package sessionstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var sessionsBucket = []byte("sessions")
+
+type boltBackend struct {
+	db *bolt.DB
+}
+
+func NewBoltBackend(path string) (*boltBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create session store directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session store %s: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(sessionsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sessions bucket: %w", err)
+	}
+
+	return &boltBackend{
+		db: db,
+	}, nil
+}
+
+func (b *boltBackend) Load(ctx context.Context, id string) (*Record, error) {
+	var record *Record
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(sessionsBucket).Get([]byte(id))
+		if value == nil {
+			return nil
+		}
+
+		record = &Record{}
+		return json.Unmarshal(value, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (b *boltBackend) Save(ctx context.Context, record *Record) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Put([]byte(record.ID), value)
+	})
+}
+
+func (b *boltBackend) Delete(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).Delete([]byte(id))
+	})
+}
+
+func (b *boltBackend) List(ctx context.Context) ([]*Record, error) {
+	return b.list(func(record *Record) bool {
+		return true
+	})
+}
+
+// ListOwned scans all records, the file store is local and doesn't need an
+// index to be cheap.
+func (b *boltBackend) ListOwned(ctx context.Context, owner string) ([]*Record, error) {
+	return b.list(func(record *Record) bool {
+		for _, recordOwner := range record.Owners {
+			if recordOwner == owner {
+				return true
+			}
+		}
+
+		return false
+	})
+}
+
+func (b *boltBackend) list(filter func(record *Record) bool) ([]*Record, error) {
+	records := make([]*Record, 0)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(key, value []byte) error {
+			record := &Record{}
+			if err := json.Unmarshal(value, record); err != nil {
+				return fmt.Errorf("failed to unmarshal session %s: %w", key, err)
+			}
+
+			if filter(record) {
+				records = append(records, record)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
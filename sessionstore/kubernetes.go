@@ -0,0 +1,131 @@
+package sessionstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	sessionSecretPrefix  = "poddy-session-"
+	sessionSecretDataKey = "session"
+	sessionLabelSelector = "managed-by=poddy,poddy-session=true"
+
+	// every owner of a session is a label of its Secret, so the sessions of
+	// a user are listed without fetching all of them
+	sessionOwnerLabelPrefix = "poddy-owner-"
+)
+
+// kubernetesBackend stores every session in its own Secret, so poddy can run
+// with multiple replicas without any additional infrastructure.
+type kubernetesBackend struct {
+	clientSet kubernetes.Interface
+	namespace string
+}
+
+func NewKubernetesBackend(clientSet kubernetes.Interface, namespace string) *kubernetesBackend {
+	return &kubernetesBackend{
+		clientSet: clientSet,
+		namespace: namespace,
+	}
+}
+
+func decodeSessionSecret(secret *corev1.Secret) (*Record, error) {
+	record := &Record{}
+	if err := json.Unmarshal(secret.Data[sessionSecretDataKey], record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session secret %s: %w", secret.Name, err)
+	}
+
+	return record, nil
+}
+
+func (k *kubernetesBackend) Load(ctx context.Context, id string) (*Record, error) {
+	secret, err := k.clientSet.CoreV1().Secrets(k.namespace).Get(ctx, sessionSecretPrefix+id, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return decodeSessionSecret(secret)
+}
+
+func (k *kubernetesBackend) Save(ctx context.Context, record *Record) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	labels := map[string]string{
+		"managed-by":    "poddy",
+		"poddy-session": "true",
+	}
+
+	for _, owner := range record.Owners {
+		labels[sessionOwnerLabelPrefix+owner] = "true"
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sessionSecretPrefix + record.ID,
+			Namespace: k.namespace,
+			Labels:    labels,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			sessionSecretDataKey: value,
+		},
+	}
+
+	_, err = k.clientSet.CoreV1().Secrets(k.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = k.clientSet.CoreV1().Secrets(k.namespace).Create(ctx, secret, metav1.CreateOptions{})
+	}
+
+	return err
+}
+
+func (k *kubernetesBackend) Delete(ctx context.Context, id string) error {
+	err := k.clientSet.CoreV1().Secrets(k.namespace).Delete(ctx, sessionSecretPrefix+id, metav1.DeleteOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+func (k *kubernetesBackend) List(ctx context.Context) ([]*Record, error) {
+	return k.list(ctx, sessionLabelSelector)
+}
+
+func (k *kubernetesBackend) ListOwned(ctx context.Context, owner string) ([]*Record, error) {
+	return k.list(ctx, fmt.Sprintf("%s,%s%s=true", sessionLabelSelector, sessionOwnerLabelPrefix, owner))
+}
+
+func (k *kubernetesBackend) list(ctx context.Context, labelSelector string) ([]*Record, error) {
+	secretList, err := k.clientSet.CoreV1().Secrets(k.namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labelSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*Record, 0, len(secretList.Items))
+	for i := range secretList.Items {
+		record, err := decodeSessionSecret(&secretList.Items[i])
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
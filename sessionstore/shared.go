@@ -0,0 +1,80 @@
+package sessionstore
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+const sharedValueName = "shared-value"
+
+// NewSharedValueId returns the id for a new shared value.
+func NewSharedValueId() string {
+	return hex.EncodeToString(securecookie.GenerateRandomKey(32))
+}
+
+// SaveSharedValue stores a value that several sessions and api tokens refer to
+// by its id, like a provider token that has to be refreshed in one place. The
+// value expires with the longest living of them, so saving it never moves the
+// expiry forward.
+func (s *Store) SaveSharedValue(ctx context.Context, id, value string, expiresAt time.Time) error {
+	existing, err := s.backend.Load(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to load shared value: %w", err)
+	}
+
+	now := time.Now()
+	record := &Record{
+		ID:        id,
+		Kind:      KindSharedValue,
+		Name:      sharedValueName,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+
+	if existing != nil && existing.Kind == KindSharedValue {
+		record.CreatedAt = existing.CreatedAt
+		if existing.ExpiresAt.After(record.ExpiresAt) {
+			record.ExpiresAt = existing.ExpiresAt
+		}
+	}
+
+	if record.ExpiresAt.IsZero() {
+		return fmt.Errorf("shared value %s has no expiry", id)
+	}
+
+	record.Data, err = securecookie.EncodeMulti(sharedValueName, value, s.recordCodecs...)
+	if err != nil {
+		return fmt.Errorf("failed to encode shared value: %w", err)
+	}
+
+	if err := s.backend.Save(ctx, record); err != nil {
+		return fmt.Errorf("failed to save shared value: %w", err)
+	}
+
+	return nil
+}
+
+// LoadSharedValue returns the value with the given id, or an empty string if
+// it doesn't exist or has expired.
+func (s *Store) LoadSharedValue(ctx context.Context, id string) (string, error) {
+	record, err := s.backend.Load(ctx, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to load shared value: %w", err)
+	}
+
+	if record == nil || record.Kind != KindSharedValue || record.isExpired(time.Now()) {
+		return "", nil
+	}
+
+	var value string
+	if err := securecookie.DecodeMulti(sharedValueName, record.Data, &value, s.recordCodecs...); err != nil {
+		return "", nil
+	}
+
+	return value, nil
+}
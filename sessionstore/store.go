@@ -0,0 +1,426 @@
+package sessionstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+	gsessions "github.com/gorilla/sessions"
+)
+
+// Record is a session as it is persisted by a backend. The session values are
+// encoded with the store's codecs, the browser cookie only holds the signed id.
+type Record struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind,omitempty"`
+	Name      string    `json:"name"`
+	Data      string    `json:"data"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Label and Scopes are only set for api tokens
+	Label  string   `json:"label,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+
+	// Owners are the hashed keys of who the session belongs to, which the
+	// backends index to list the sessions of a user without decoding all
+	Owners []string `json:"owners,omitempty"`
+}
+
+const (
+	KindSession     = ""
+	KindApiToken    = "api-token"
+	KindSharedValue = "shared-value"
+)
+
+func (r *Record) isExpired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && now.After(r.ExpiresAt)
+}
+
+type Backend interface {
+	// Load returns the record with the given id or nil if it doesn't exist.
+	Load(ctx context.Context, id string) (*Record, error)
+	Save(ctx context.Context, record *Record) error
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]*Record, error)
+	// ListOwned returns the records with the given hashed owner key.
+	ListOwned(ctx context.Context, owner string) ([]*Record, error)
+}
+
+// SessionInfo describes a stored session for listing and revoking it. The
+// handle is derived from the session id, so listing sessions never hands out
+// ids that could be used to take over a session.
+type SessionInfo struct {
+	id        string
+	Handle    string
+	Kind      string
+	Label     string
+	Scopes    []string
+	Values    map[interface{}]interface{}
+	UserAgent string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type Store struct {
+	backend Backend
+	codecs  []securecookie.Codec
+	options *gsessions.Options
+
+	// api tokens and shared values outlive the session max age, their
+	// records expire on their own instead
+	recordCodecs []securecookie.Codec
+
+	owners func(values map[interface{}]interface{}) []string
+}
+
+func NewStore(backend Backend, keyPairs ...[]byte) *Store {
+	store := &Store{
+		backend: backend,
+		codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		options: &gsessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		recordCodecs: securecookie.CodecsFromPairs(keyPairs...),
+	}
+
+	// the values never end up in a cookie, so they aren't bound by its size limit
+	for _, codec := range append(append([]securecookie.Codec{}, store.codecs...), store.recordCodecs...) {
+		if secureCookie, ok := codec.(*securecookie.SecureCookie); ok {
+			secureCookie.MaxLength(0)
+		}
+	}
+
+	for _, codec := range store.recordCodecs {
+		if secureCookie, ok := codec.(*securecookie.SecureCookie); ok {
+			secureCookie.MaxAge(0)
+		}
+	}
+
+	store.setMaxAge(store.options.MaxAge)
+
+	return store
+}
+
+func HandleForId(id string) string {
+	hash := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(hash[:16])
+}
+
+// SetOwners sets the function that tells from the values of a session or api
+// token who it belongs to. The owners are stored hashed with the record.
+func (s *Store) SetOwners(owners func(values map[interface{}]interface{}) []string) {
+	s.owners = owners
+}
+
+func ownerKey(owner string) string {
+	hash := sha256.Sum256([]byte("owner|" + owner))
+	return hex.EncodeToString(hash[:16])
+}
+
+func (s *Store) ownerKeys(values map[interface{}]interface{}) []string {
+	if s.owners == nil {
+		return nil
+	}
+
+	owners := s.owners(values)
+	if len(owners) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(owners))
+	for i, owner := range owners {
+		keys[i] = ownerKey(owner)
+	}
+
+	return keys
+}
+
+// codecsFor returns the codecs the data of records of the kind is encoded
+// with.
+func (s *Store) codecsFor(kind string) []securecookie.Codec {
+	if kind == KindSession {
+		return s.codecs
+	}
+
+	return s.recordCodecs
+}
+
+func (s *Store) setMaxAge(maxAge int) {
+	for _, codec := range s.codecs {
+		if secureCookie, ok := codec.(*securecookie.SecureCookie); ok {
+			secureCookie.MaxAge(maxAge)
+		}
+	}
+}
+
+func (s *Store) Options(options sessions.Options) {
+	s.options = options.ToGorillaOptions()
+	s.setMaxAge(s.options.MaxAge)
+}
+
+func (s *Store) Get(r *http.Request, name string) (*gsessions.Session, error) {
+	return gsessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session referenced by the cookie of the request. Unknown,
+// expired or revoked sessions silently start over as a new session.
+func (s *Store) New(r *http.Request, name string) (*gsessions.Session, error) {
+	session := gsessions.NewSession(s, name)
+	options := *s.options
+	session.Options = &options
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &id, s.codecs...); err != nil {
+		return session, nil
+	}
+
+	record, err := s.backend.Load(r.Context(), id)
+	if err != nil {
+		return session, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	if record == nil || record.Kind != KindSession || record.isExpired(time.Now()) {
+		return session, nil
+	}
+
+	if err := securecookie.DecodeMulti(name, record.Data, &session.Values, s.codecs...); err != nil {
+		return session, fmt.Errorf("failed to decode session: %w", err)
+	}
+
+	session.ID = id
+	session.IsNew = false
+
+	s.indexOwners(r.Context(), record, session.Values)
+
+	return session, nil
+}
+
+// indexOwners adds the owners to records saved by earlier versions without
+// them, so they show up in the sessions of their user once they are used.
+func (s *Store) indexOwners(ctx context.Context, record *Record, values map[interface{}]interface{}) {
+	if len(record.Owners) > 0 {
+		return
+	}
+
+	record.Owners = s.ownerKeys(values)
+	if len(record.Owners) == 0 {
+		return
+	}
+
+	if err := s.backend.Save(ctx, record); err != nil {
+		log.Printf("failed to index owners of session: %v\n", err)
+	}
+}
+
+// regenerateIdKey marks a session whose id is replaced on its next save
+const regenerateIdKey = "_regenerate_id"
+
+// RegenerateId replaces the id of the session when it is saved and deletes
+// the record stored under the old id. It is called whenever the session is
+// logged in, so an id planted in the browser before can't be used to ride
+// along. Cookie sessions have no id and nothing to regenerate.
+func RegenerateId(session sessions.Session) {
+	if len(session.ID()) > 0 {
+		session.Set(regenerateIdKey, true)
+	}
+}
+
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *gsessions.Session) error {
+	regenerateId, _ := session.Values[regenerateIdKey].(bool)
+	delete(session.Values, regenerateIdKey)
+
+	if regenerateId && len(session.ID) > 0 {
+		if err := s.backend.Delete(r.Context(), session.ID); err != nil {
+			return fmt.Errorf("failed to delete session: %w", err)
+		}
+
+		session.ID = ""
+	}
+
+	if session.Options.MaxAge < 0 {
+		if len(session.ID) > 0 {
+			if err := s.backend.Delete(r.Context(), session.ID); err != nil {
+				return fmt.Errorf("failed to delete session: %w", err)
+			}
+		}
+
+		http.SetCookie(w, gsessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	now := time.Now()
+	record := &Record{
+		ID:        session.ID,
+		Name:      session.Name(),
+		UserAgent: r.UserAgent(),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if len(record.ID) == 0 {
+		record.ID = hex.EncodeToString(securecookie.GenerateRandomKey(32))
+	} else {
+		existing, err := s.backend.Load(r.Context(), record.ID)
+		if err != nil {
+			return fmt.Errorf("failed to load session: %w", err)
+		}
+
+		if existing != nil {
+			record.CreatedAt = existing.CreatedAt
+		}
+	}
+
+	if session.Options.MaxAge > 0 {
+		record.ExpiresAt = now.Add(time.Duration(session.Options.MaxAge) * time.Second)
+	}
+
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.codecs...)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	record.Data = data
+	record.Owners = s.ownerKeys(session.Values)
+
+	if err := s.backend.Save(r.Context(), record); err != nil {
+		return fmt.Errorf("failed to save session: %w", err)
+	}
+
+	encodedId, err := securecookie.EncodeMulti(session.Name(), record.ID, s.codecs...)
+	if err != nil {
+		return fmt.Errorf("failed to encode session id: %w", err)
+	}
+
+	session.ID = record.ID
+	http.SetCookie(w, gsessions.NewCookie(session.Name(), encodedId, session.Options))
+
+	return nil
+}
+
+// LoadValues returns the values currently stored for the session or api
+// token with the given id, or nil if it doesn't exist or has expired. Requests
+// hold the values as they were when the request started, this is what other
+// requests saved since.
+func (s *Store) LoadValues(ctx context.Context, id string) (map[interface{}]interface{}, error) {
+	record, err := s.backend.Load(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session: %w", err)
+	}
+
+	if record == nil || record.Kind == KindSharedValue || record.isExpired(time.Now()) {
+		return nil, nil
+	}
+
+	values := make(map[interface{}]interface{})
+	if err := securecookie.DecodeMulti(record.Name, record.Data, &values, s.codecsFor(record.Kind)...); err != nil {
+		return nil, nil
+	}
+
+	return values, nil
+}
+
+// ListSessions returns the sessions and api tokens of any of the owners that
+// haven't expired yet.
+func (s *Store) ListSessions(ctx context.Context, owners []string) ([]SessionInfo, error) {
+	now := time.Now()
+	sessionInfos := make([]SessionInfo, 0)
+	seen := make(map[string]bool)
+
+	for _, owner := range owners {
+		records, err := s.backend.ListOwned(ctx, ownerKey(owner))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list sessions: %w", err)
+		}
+
+		for _, record := range records {
+			if seen[record.ID] || record.Kind == KindSharedValue || record.isExpired(now) {
+				continue
+			}
+
+			seen[record.ID] = true
+
+			values := make(map[interface{}]interface{})
+			if err := securecookie.DecodeMulti(record.Name, record.Data, &values, s.codecsFor(record.Kind)...); err != nil {
+				// sessions encoded with a retired key can't be used anymore anyways
+				continue
+			}
+
+			sessionInfos = append(sessionInfos, SessionInfo{
+				id:        record.ID,
+				Handle:    HandleForId(record.ID),
+				Kind:      record.Kind,
+				Label:     record.Label,
+				Scopes:    record.Scopes,
+				Values:    values,
+				UserAgent: record.UserAgent,
+				CreatedAt: record.CreatedAt,
+				UpdatedAt: record.UpdatedAt,
+				ExpiresAt: record.ExpiresAt,
+			})
+		}
+	}
+
+	return sessionInfos, nil
+}
+
+// RevokeSession deletes the listed session, the browser holding it starts
+// over with a new session on its next request.
+func (s *Store) RevokeSession(ctx context.Context, sessionInfo SessionInfo) error {
+	if len(sessionInfo.id) == 0 {
+		return fmt.Errorf("session %s wasn't listed by the store", sessionInfo.Handle)
+	}
+
+	return s.backend.Delete(ctx, sessionInfo.id)
+}
+
+func (s *Store) PurgeExpired(ctx context.Context) error {
+	records, err := s.backend.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	now := time.Now()
+	for _, record := range records {
+		if record.isExpired(now) {
+			if err := s.backend.Delete(ctx, record.ID); err != nil {
+				return fmt.Errorf("failed to delete session: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RunPurge periodically deletes expired sessions until the context is done.
+func (s *Store) RunPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.PurgeExpired(ctx); err != nil {
+				log.Printf("failed to purge expired sessions: %v\n", err)
+			}
+		}
+	}
+}
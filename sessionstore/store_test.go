@@ -0,0 +1,98 @@
+package sessionstore
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gsessions "github.com/gorilla/sessions"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testBackends(t *testing.T) map[string]Backend {
+	boltBackend, err := NewBoltBackend(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("failed to create bolt backend: %v", err)
+	}
+
+	return map[string]Backend{
+		"bolt":       boltBackend,
+		"kubernetes": NewKubernetesBackend(fake.NewSimpleClientset(), "poddy"),
+	}
+}
+
+func TestListAndRevokeOwnedSessions(t *testing.T) {
+	for name, backend := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			store := NewStore(backend, []byte("0123456789abcdef0123456789abcdef"))
+			store.SetOwners(func(values map[interface{}]interface{}) []string {
+				owner, _ := values["owner"].(string)
+				if len(owner) == 0 {
+					return nil
+				}
+
+				return []string{owner}
+			})
+
+			saveSession := func(owner string) {
+				session := gsessions.NewSession(store, "poddy")
+				session.Options = &gsessions.Options{MaxAge: 3600}
+				if len(owner) > 0 {
+					session.Values["owner"] = owner
+				}
+
+				if err := store.Save(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder(), session); err != nil {
+					t.Fatalf("failed to save session: %v", err)
+				}
+			}
+
+			saveSession("alice")
+			saveSession("alice")
+			saveSession("bob")
+			saveSession("")
+
+			if _, err := store.CreateApiToken(ctx, "cli", nil, map[interface{}]interface{}{"owner": "alice"}, time.Now().Add(time.Hour)); err != nil {
+				t.Fatalf("failed to create api token: %v", err)
+			}
+
+			sessionInfos, err := store.ListSessions(ctx, []string{"alice"})
+			if err != nil {
+				t.Fatalf("failed to list sessions: %v", err)
+			}
+
+			kinds := make(map[string]int)
+			for _, sessionInfo := range sessionInfos {
+				if sessionInfo.Values["owner"] != "alice" {
+					t.Errorf("listed session of %v", sessionInfo.Values["owner"])
+				}
+
+				kinds[sessionInfo.Kind]++
+			}
+
+			if kinds[KindSession] != 2 || kinds[KindApiToken] != 1 {
+				t.Fatalf("listed %d sessions and %d api tokens, want 2 and 1", kinds[KindSession], kinds[KindApiToken])
+			}
+
+			if err := store.RevokeSession(ctx, sessionInfos[0]); err != nil {
+				t.Fatalf("failed to revoke session: %v", err)
+			}
+
+			if err := store.RevokeSession(ctx, SessionInfo{Handle: sessionInfos[1].Handle}); err == nil {
+				t.Errorf("revoked a session that wasn't listed")
+			}
+
+			sessionInfos, err = store.ListSessions(ctx, []string{"alice", "bob"})
+			if err != nil {
+				t.Fatalf("failed to list sessions: %v", err)
+			}
+
+			if len(sessionInfos) != 3 {
+				t.Errorf("listed %d sessions after revoking one, want 3", len(sessionInfos))
+			}
+		})
+	}
+}
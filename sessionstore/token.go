@@ -0,0 +1,177 @@
+package sessionstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gorilla/securecookie"
+)
+
+const (
+	apiTokenPrefix = "poddy_"
+	apiTokenName   = "api-token"
+
+	// last use is only recorded with this granularity to not write the
+	// record on every request
+	apiTokenTouchInterval = time.Minute
+)
+
+// api tokens are stored under the hash of the token, so the stored records
+// can't be used to authenticate
+func apiTokenId(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateApiToken stores the given session values as an api token and returns
+// the token, which can't be recovered later on.
+func (s *Store) CreateApiToken(ctx context.Context, label string, scopes []string, values map[interface{}]interface{}, expiresAt time.Time) (string, error) {
+	token := apiTokenPrefix + hex.EncodeToString(securecookie.GenerateRandomKey(32))
+
+	if expiresAt.IsZero() {
+		return "", errors.New("api tokens must expire")
+	}
+
+	data, err := securecookie.EncodeMulti(apiTokenName, values, s.recordCodecs...)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode api token: %w", err)
+	}
+
+	now := time.Now()
+	if err := s.backend.Save(ctx, &Record{
+		ID:        apiTokenId(token),
+		Kind:      KindApiToken,
+		Name:      apiTokenName,
+		Data:      data,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+		Label:     label,
+		Scopes:    scopes,
+		Owners:    s.ownerKeys(values),
+	}); err != nil {
+		return "", fmt.Errorf("failed to save api token: %w", err)
+	}
+
+	return token, nil
+}
+
+// LoadApiToken returns the session of the api token or nil if the token is
+// unknown, revoked or expired. Tokens without an expiry are rejected.
+func (s *Store) LoadApiToken(ctx context.Context, token string) (*ApiTokenSession, error) {
+	record, err := s.backend.Load(ctx, apiTokenId(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load api token: %w", err)
+	}
+
+	now := time.Now()
+	if record == nil || record.Kind != KindApiToken || record.ExpiresAt.IsZero() || record.isExpired(now) {
+		return nil, nil
+	}
+
+	values := make(map[interface{}]interface{})
+	if err := securecookie.DecodeMulti(record.Name, record.Data, &values, s.recordCodecs...); err != nil {
+		return nil, nil
+	}
+
+	tokenSession := &ApiTokenSession{
+		ctx:    ctx,
+		store:  s,
+		record: record,
+		values: values,
+	}
+
+	// tokens saved by earlier versions are saved right away to index their owners
+	if now.Sub(record.UpdatedAt) > apiTokenTouchInterval || (len(record.Owners) == 0 && len(s.ownerKeys(values)) > 0) {
+		if err := tokenSession.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return tokenSession, nil
+}
+
+// ApiTokenSession makes the values stored with an api token available as a
+// regular session, so handlers work the same for cookies and api tokens.
+// Changed values, like refreshed provider tokens, are written back to the
+// api token.
+type ApiTokenSession struct {
+	ctx     context.Context
+	store   *Store
+	record  *Record
+	values  map[interface{}]interface{}
+	written bool
+}
+
+var _ sessions.Session = &ApiTokenSession{}
+
+func (t *ApiTokenSession) Scopes() []string {
+	return t.record.Scopes
+}
+
+func (t *ApiTokenSession) ID() string {
+	return t.record.ID
+}
+
+func (t *ApiTokenSession) Get(key interface{}) interface{} {
+	return t.values[key]
+}
+
+func (t *ApiTokenSession) Set(key interface{}, val interface{}) {
+	t.values[key] = val
+	t.written = true
+}
+
+func (t *ApiTokenSession) Delete(key interface{}) {
+	delete(t.values, key)
+	t.written = true
+}
+
+func (t *ApiTokenSession) Clear() {
+	for key := range t.values {
+		t.Delete(key)
+	}
+}
+
+func (t *ApiTokenSession) AddFlash(value interface{}, vars ...string) {}
+
+func (t *ApiTokenSession) Flashes(vars ...string) []interface{} {
+	return nil
+}
+
+func (t *ApiTokenSession) Options(options sessions.Options) {}
+
+func (t *ApiTokenSession) Save() error {
+	if !t.written {
+		return nil
+	}
+
+	if err := t.save(); err != nil {
+		return err
+	}
+
+	t.written = false
+
+	return nil
+}
+
+func (t *ApiTokenSession) save() error {
+	data, err := securecookie.EncodeMulti(t.record.Name, t.values, t.store.recordCodecs...)
+	if err != nil {
+		return fmt.Errorf("failed to encode api token: %w", err)
+	}
+
+	t.record.Data = data
+	t.record.UpdatedAt = time.Now()
+	t.record.Owners = t.store.ownerKeys(t.values)
+
+	if err := t.store.backend.Save(t.ctx, t.record); err != nil {
+		return fmt.Errorf("failed to save api token: %w", err)
+	}
+
+	return nil
+}